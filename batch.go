@@ -0,0 +1,124 @@
+package gocache
+
+import (
+	"time"
+)
+
+// op is a write to the back map to replay on the other map after a swap, a nil item deletes.
+type op[K comparable, V any] struct {
+	key  K
+	item *item[V]
+}
+
+// SetBatching makes every bucket publish its writes in batches of size, so a burst of writes swaps
+// front and back once instead of once per write. A batch is published when it is full, when its oldest
+// write waited delay, on refresh, on Flush or on Close. Until then Get does not see the writes of the
+// batch, call Flush to read your writes. size 1 or less publishes every write at once, which is the default.
+// Set it before the cache is used, delay is only enforced while Run runs.
+func (r *Cache[K, V]) SetBatching(size int, delay time.Duration) {
+	for i := 0; i < r.bucketSize; i++ {
+		b := &r.caches[i]
+
+		b.lockBack()
+		b.batchSize = size
+		b.batchDelay = delay
+		b.flush()
+		b.unlockBack()
+	}
+
+	r.life.lock.Lock()
+	r.batchDelay = delay
+	r.life.lock.Unlock()
+}
+
+// Flush publishes the pending writes of every bucket, so the next Get sees them.
+func (r *Cache[K, V]) Flush() {
+	for i := 0; i < r.bucketSize; i++ {
+		r.caches[i].flushPending()
+	}
+}
+
+// flushOlder publishes the batches whose oldest write waited their delay at now.
+func (r *Cache[K, V]) flushOlder(now time.Time) {
+	for i := 0; i < r.bucketSize; i++ {
+		b := &r.caches[i]
+
+		b.lockBack()
+		if b.batchDelay > 0 && len(b.pending) > 0 && now.Sub(b.pendingSince) >= b.batchDelay {
+			b.flush()
+		}
+		b.unlockBack()
+	}
+}
+
+func (r *BaseCache[K, V]) flushPending() {
+	r.lockBack()
+	defer r.unlockBack()
+
+	r.flush()
+}
+
+// set writes the back map and logs it. block must be held.
+func (r *BaseCache[K, V]) set(key K, stored *item[V]) {
+	if old, ok := (*r.back)[key]; ok {
+		r.used = r.used.sub(old.usage())
+	}
+	r.used = r.used.add(stored.usage())
+
+	(*r.back)[key] = stored
+	r.log(op[K, V]{key: key, item: stored})
+}
+
+// remove deletes from the back map and logs it. block must be held.
+func (r *BaseCache[K, V]) remove(key K, reason EvictReason) {
+	old, ok := (*r.back)[key]
+	if !ok {
+		return
+	}
+	r.notify(key, old, reason)
+	r.used = r.used.sub(old.usage())
+
+	delete(*r.back, key)
+	r.log(op[K, V]{key: key})
+}
+
+func (r *BaseCache[K, V]) log(o op[K, V]) {
+	if len(r.pending) == 0 {
+		r.pendingSince = r.clock.Now()
+	}
+	r.pending = append(r.pending, o)
+}
+
+// commit publishes the pending writes unless they can wait for a bigger batch. block must be held.
+func (r *BaseCache[K, V]) commit(now time.Time) {
+	if r.batchSize > 1 && len(r.pending) < r.batchSize &&
+		(r.batchDelay <= 0 || len(r.pending) == 0 || now.Sub(r.pendingSince) < r.batchDelay) {
+		return
+	}
+
+	r.flush()
+}
+
+// flush swaps front and back once and replays the pending writes on the new back. block must be held.
+func (r *BaseCache[K, V]) flush() {
+	if len(r.pending) == 0 {
+		return
+	}
+
+	r.swap()
+
+	for i, o := range r.pending {
+		if o.item == nil {
+			delete(*r.back, o.key)
+		} else {
+			(*r.back)[o.key] = o.item
+		}
+
+		r.pending[i] = op[K, V]{}
+	}
+	r.pending = r.pending[:0]
+
+	// readers can not find the removed items anymore, listeners may release them
+	r.published = append(r.published, r.evicted...)
+	r.evicted = r.evicted[:0]
+}
@@ -0,0 +1,628 @@
+package gocache
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is the type-safe bucketed double buffering cache. Keys are used as map keys as they are,
+// hash only picks the bucket of a key.
+type Cache[K comparable, V any] struct {
+	caches     []BaseCache[K, V]
+	size       int64 // item limit, or the cost budget of WithMaxCost
+	bucketSize int
+	capacity   int64 // cost left, an item costs 1 unless WithCost or a WithCost write says otherwise
+	missSize   int64 // limit number of cached misses
+	missLeft   int64 // cached misses left
+	hash       func(key K) uint
+	cost       func(value V) int64 // nil costs 1 per item
+	life       lifecycle
+	clock      Clock
+
+	refreshDuration time.Duration
+	batchDelay      time.Duration // guarded by life.lock
+	policy          EvictionPolicy
+	loads           flightGroup[K, V]
+	keyCodec        Codec[K]
+	valueCodec      Codec[V]
+	listeners       *evictListeners[K, V]
+	loader          Loader[K, V]       // nil if stale items are not reloaded
+	readThrough     bool               // Get loads misses by loader
+	writer          Writer[K, V]       // nil if writes stay in the cache
+	behind          *writeBehind[K, V] // nil for write-through
+	writeTimeout    time.Duration      // of a Write, 0 for no limit
+	writeLocks      [writeStripes]sync.Mutex
+	staleWindow     time.Duration
+	grace           time.Duration
+	ahead           float64       // fraction of the TTL of hot items they are reloaded ahead in, 0 for never
+	minRate         float64       // hits per second of hot items
+	beta            float64       // XFetch beta, 0 for no early expiration
+	lastLoad        atomic.Int64  // time.Duration of the last reload of any key
+	evictFrom       atomic.Uint32 // bucket evictOthers starts at, rotated so no bucket is drained first every time
+	reloads         reloads[K]
+
+	refreshes   atomic.Uint64
+	lastRefresh atomic.Int64 // time.Duration of the last refresh of all buckets
+}
+
+// generic gocache factory method, NewCacheWithOptions validates its arguments instead of panicking.
+// parameters
+// - hash func(key K) uint : generating function index of buckets, nil for NewHasher
+// - refreshDuration time.Duration : refresh duration of caches remove too old to keep
+// - size int : limit number of cached instance
+// - bucketSize int : a number of separated caches (to avoid lock)
+// - policy EvictionPolicy : victim of a full bucket for a new key, nil rejects new keys when full
+func NewCache[K comparable, V any](hash func(key K) uint, refreshDuration time.Duration, size int, bucketSize int, policy EvictionPolicy) *Cache[K, V] {
+	opts := []Option{WithRefreshInterval(refreshDuration), WithCapacity(size), WithBuckets(bucketSize), WithEvictionPolicy(policy)}
+	if hash != nil {
+		opts = append(opts, WithHasher(hash))
+	}
+
+	ret, err := NewCacheWithOptions[K, V](opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return ret
+}
+
+type writeMode int
+
+const (
+	modeAdd     writeMode = iota // insert only if absent
+	modeReplace                  // update only if present
+	modeSet                      // insert or update
+)
+
+type writeResult int
+
+const (
+	written writeResult = iota
+	rejectedExists
+	rejectedAbsent
+	rejectedFull
+)
+
+type item[V any] struct {
+	accessed int64  // unix nano of the last hit, kept only when evicting or refreshing ahead
+	hits     uint32 // kept only when evicting or refreshing ahead
+	retry    int64  // unix nano the next reload waits for after a failed one
+	load     int64  // time.Duration of the last reload of the key, kept by replacements
+	v        V
+	time     time.Time // removed after
+	stale    time.Time // served while revalidating after, up to time
+	created  time.Time // first write of the key, kept by replacements
+	updated  time.Time // last write of the key
+	cost     int64
+	miss     bool // a cached miss of StoreMiss, v is the zero value
+}
+
+// usage is what items take of the capacities of a cache.
+type usage struct {
+	cost   int64 // of the values
+	misses int64 // number of cached misses
+}
+
+func (r usage) sub(other usage) usage {
+	return usage{cost: r.cost - other.cost, misses: r.misses - other.misses}
+}
+
+// of is the cost, or the number of misses if miss is set.
+func (r usage) of(miss bool) int64 {
+	if miss {
+		return r.misses
+	}
+	return r.cost
+}
+
+func (r usage) add(other usage) usage {
+	return usage{cost: r.cost + other.cost, misses: r.misses + other.misses}
+}
+
+func (r *item[V]) usage() usage {
+	if r.miss {
+		return usage{misses: 1}
+	}
+	return usage{cost: r.cost}
+}
+
+func (r *item[V]) expired(now time.Time) bool {
+	return now.After(r.time)
+}
+
+func (r *item[V]) isStale(now time.Time) bool {
+	return now.After(r.stale)
+}
+
+// Start runs the refresh loop until Stop. Prefer Run and Close.
+func (r *Cache[K, V]) Start() {
+	r.Run(context.Background())
+}
+
+// Stop is Close.
+func (r *Cache[K, V]) Stop() {
+	r.Close()
+}
+
+func (r *Cache[K, V]) refresh() {
+	start := time.Now()
+
+	var sum usage
+	for i := 0; i < r.bucketSize; i++ {
+		sum = sum.add(r.caches[i].refresh())
+	}
+
+	atomic.StoreInt64(&r.capacity, r.size-sum.cost)
+	atomic.StoreInt64(&r.missLeft, r.missSize-sum.misses)
+
+	r.refreshes.Add(1)
+	r.lastRefresh.Store(int64(time.Since(start)))
+}
+
+// Get returns the live value of key and whether there was one, a miss cached by StoreMiss is none.
+// With WithStaleWhileRevalidate, the value may be stale, its reload by WithLoader runs in the background then.
+// With WithReadThrough, a miss is loaded like Load does, failed loads are none.
+func (r *Cache[K, V]) Get(key K) (V, bool) {
+	v, presence := r.Lookup(key)
+	if presence == Unknown && r.readThrough {
+		v, err := r.load(context.Background(), key, func(ctx context.Context) (V, time.Duration, error) {
+			return r.loader.Load(ctx, key)
+		})
+		return v, err == nil
+	}
+
+	return v, presence == Present
+}
+
+// Lookup returns the live value of key, if it is Present. It tells a miss cached by StoreMiss, Absent,
+// from a key the cache knows nothing about, Unknown.
+func (r *Cache[K, V]) Lookup(key K) (V, Presence) {
+	stored, presence := r.lookup(key)
+	if stored == nil {
+		var zero V
+		return zero, presence
+	}
+
+	return stored.v, presence
+}
+
+// LookupWithTTL is Lookup with how long the value or the miss is left to live, 0 for a stale value
+// of WithStaleWhileRevalidate or if nothing is cached.
+func (r *Cache[K, V]) LookupWithTTL(key K) (V, Presence, time.Duration) {
+	stored, presence := r.lookup(key)
+	if stored == nil {
+		var zero V
+		return zero, presence, 0
+	}
+
+	return stored.v, presence, max(stored.stale.Sub(r.clock.Now()), 0)
+}
+
+// lookup returns the live item of key, counting the lookup and revalidating the item if it is due.
+func (r *Cache[K, V]) lookup(key K) (*item[V], Presence) {
+	idx := r.getBucketIndex(key)
+
+	stored := r.caches[idx].get(key)
+
+	if stored == nil {
+		r.caches[idx].stats.misses.Add(1)
+		return nil, Unknown
+	}
+
+	if stored.miss {
+		r.caches[idx].stats.negativeHits.Add(1)
+		return stored, Absent
+	}
+
+	r.caches[idx].stats.hits.Add(1)
+	if r.staleWindow > 0 || r.ahead > 0 {
+		now := r.clock.Now()
+		switch {
+		case stored.isStale(now):
+			r.caches[idx].stats.staleHits.Add(1)
+			r.revalidate(key, stored)
+		case r.ahead > 0 && r.early(stored, now):
+			r.revalidate(key, stored)
+		}
+	}
+
+	return stored, Present
+}
+
+func (r *Cache[K, V]) getBucketIndex(key K) uint {
+	return r.hash(key) % uint(r.bucketSize)
+}
+
+// computedCost makes write ask WithCost for the cost of a value.
+const computedCost = -1
+
+// Store caches value for duration only if key is not cached yet. It is the same as Add.
+func (r *Cache[K, V]) Store(key K, value V, duration time.Duration) bool {
+	return r.put(key, value, computedCost, duration, modeAdd)
+}
+
+// StoreWithCost is Store with the cost of value given instead of computed by WithCost.
+// A negative cost is computed.
+func (r *Cache[K, V]) StoreWithCost(key K, value V, cost int64, duration time.Duration) bool {
+	return r.put(key, value, cost, duration, modeAdd)
+}
+
+// Set caches value for duration, replacing the value and expiry of key if it is already cached.
+func (r *Cache[K, V]) Set(key K, value V, duration time.Duration) bool {
+	return r.put(key, value, computedCost, duration, modeSet)
+}
+
+// SetWithCost is Set with the cost of value given instead of computed by WithCost.
+// A negative cost is computed.
+func (r *Cache[K, V]) SetWithCost(key K, value V, cost int64, duration time.Duration) bool {
+	return r.put(key, value, cost, duration, modeSet)
+}
+
+// Add caches value for duration only if key is not cached yet.
+func (r *Cache[K, V]) Add(key K, value V, duration time.Duration) bool {
+	return r.put(key, value, computedCost, duration, modeAdd)
+}
+
+// Replace updates the value and expiry of key only if it is already cached.
+func (r *Cache[K, V]) Replace(key K, value V, duration time.Duration) bool {
+	return r.put(key, value, computedCost, duration, modeReplace)
+}
+
+func (r *Cache[K, V]) costOf(value V, cost int64) int64 {
+	if cost >= 0 {
+		return cost
+	}
+	if r.cost == nil {
+		return 1
+	}
+
+	return max(r.cost(value), 0)
+}
+
+// write stores value, or a cached miss. Values take their cost of capacity and misses one of missLeft.
+func (r *Cache[K, V]) write(key K, value V, cost int64, duration time.Duration, mode writeMode, miss bool) bool {
+	idx := r.getBucketIndex(key)
+
+	left, size := &r.capacity, r.size
+	if miss {
+		cost, left, size = 1, &r.missLeft, r.missSize
+	} else {
+		cost = r.costOf(value, cost)
+	}
+
+	if cost > size { // would never fit
+		r.caches[idx].stats.count(rejectedFull)
+		return false
+	}
+
+	// reserve the cost first, so concurrent writes can not both take what is left
+	available := atomic.AddInt64(left, -cost) + cost
+	need := cost - max(available, 0) // what the bucket has to free for the write
+
+	if need > 0 && mode == modeAdd && r.policy == nil {
+		atomic.AddInt64(left, cost)
+		r.caches[idx].stats.count(rejectedFull)
+		return false
+	}
+
+	reserved := usage{cost: cost}
+	if miss {
+		reserved = usage{misses: 1}
+	}
+
+	taken, result := r.caches[idx].write(key, value, reserved, duration, mode, need, miss)
+
+	if result == rejectedFull && need > 0 && r.policy != nil {
+		// the budget is shared by all buckets, so the other buckets evict what the bucket of key could not
+		freed := r.evictOthers(idx, need+taken.of(miss), miss)
+		if freed.of(miss) > 0 {
+			var retaken usage
+			retaken, result = r.caches[idx].write(key, value, reserved, duration, mode, need+taken.of(miss)-freed.of(miss), miss)
+			taken = taken.add(retaken)
+		}
+		r.release(freed)
+	}
+
+	r.release(reserved.sub(taken)) // give back whatever was reserved but not taken
+	r.caches[idx].stats.count(result)
+
+	return result == written
+}
+
+func (r *Cache[K, V]) release(freed usage) {
+	if freed.cost != 0 {
+		atomic.AddInt64(&r.capacity, freed.cost)
+	}
+	if freed.misses != 0 {
+		atomic.AddInt64(&r.missLeft, freed.misses)
+	}
+}
+
+// Delete removes key from the cache immediately and gives its slot back.
+// It reports whether key was cached.
+func (r *Cache[K, V]) Delete(key K) bool {
+	return r.DeleteMany(key) == 1
+}
+
+// DeleteMany removes keys from the cache immediately and returns how many of them were cached.
+// keys are grouped by bucket, so each bucket is swapped at most once. With WithWriter, every key
+// is deleted from the backing store too, cached or not, a failure is counted in Stats.
+func (r *Cache[K, V]) DeleteMany(keys ...K) int {
+	if r.writer == nil {
+		return r.deleteMany(keys)
+	}
+
+	unlock := r.lockKeys(keys) // ordered with the writes of keys, see put
+	defer unlock()
+
+	deleted := r.deleteMany(keys)
+
+	changes := make([]Change[K, V], len(keys))
+	for i, key := range keys {
+		changes[i] = Change[K, V]{Key: key, Deleted: true}
+	}
+	r.writeBack(changes)
+
+	return deleted
+}
+
+// deleteMany removes keys from the cache only.
+func (r *Cache[K, V]) deleteMany(keys []K) int {
+	groups := make(map[uint][]K)
+	for _, key := range keys {
+		idx := r.getBucketIndex(key)
+		groups[idx] = append(groups[idx], key)
+	}
+
+	deleted := 0
+	for idx, group := range groups {
+		n, freed := r.caches[idx].delete(group)
+		deleted += n
+		r.release(freed)
+	}
+
+	return deleted
+}
+
+type BaseCache[K comparable, V any] struct {
+	front         atomic.Pointer[map[K]*item[V]] // map to read, without a lock.
+	back          *map[K]*item[V]                // map to back write. write back -> swap with front -> replay the writes on back
+	readers       [2]atomic.Int64                // readers of front by version parity, so swap knows when the old front is free
+	version       atomic.Uint32                  // incremented by every swap
+	block         sync.RWMutex                   // lock for back
+	keyBufferSize int
+
+	expired map[K]struct{}   // keys get found expired, removed by the next write or refresh
+	elock   sync.Mutex       // lock for expired
+	expiry  expiryHeap[K, V] // items by expiry, guarded by block
+	clock   Clock
+	policy  EvictionPolicy // nil if full buckets reject new keys
+	track   bool           // whether get records hits on items
+
+	used        usage         // of the items of the back map, guarded by block
+	staleWindow time.Duration // items are served stale for after their TTL
+
+	pending      []op[K, V]    // writes to the back map not replayed on the front yet, guarded by block
+	pendingSince time.Time     // when the oldest pending write was logged
+	batchSize    int           // pending writes published at once, 1 or less publishes every write
+	batchDelay   time.Duration // longest a pending write waits, 0 for no limit
+
+	listeners *evictListeners[K, V]
+	evicted   []eviction[K, V] // removed from the back map, not published yet, guarded by block
+	published []eviction[K, V] // published, to tell listeners once block is released, guarded by block
+
+	stats counters
+}
+
+// get returns the live item of key. An expired item is a miss and is queued for removal,
+// so TTLs hold exactly no matter how often refresh runs.
+func (r *BaseCache[K, V]) get(key K) *item[V] {
+	stored := r.lookup(key)
+	if stored == nil {
+		return nil
+	}
+
+	now := r.clock.Now()
+	if stored.expired(now) {
+		r.queueExpired(key)
+		return nil
+	}
+
+	if r.track {
+		stored.touch(now)
+	}
+
+	return stored
+}
+
+// lookup reads front without a lock. A reader announces itself on the counter of the current version
+// before it loads front, so swap can wait out everyone who may still read the old one (left-right).
+func (r *BaseCache[K, V]) lookup(key K) *item[V] {
+	v := r.version.Load() & 1
+	r.readers[v].Add(1)
+	ret := (*r.front.Load())[key]
+	r.readers[v].Add(-1)
+	return ret
+}
+
+func (r *BaseCache[K, V]) queueExpired(key K) {
+	r.elock.Lock()
+	r.expired[key] = struct{}{}
+	r.elock.Unlock()
+}
+
+// drainExpired removes the keys queued by get if they are still expired and returns how many it removed.
+// block must be held.
+func (r *BaseCache[K, V]) drainExpired(now time.Time) int {
+	r.elock.Lock()
+	queued := r.expired
+	if len(queued) > 0 {
+		r.expired = make(map[K]struct{})
+	}
+	r.elock.Unlock()
+
+	removed := 0
+	for key := range queued {
+		stored, ok := (*r.back)[key]
+		if !ok || !stored.expired(now) {
+			continue
+		}
+
+		r.remove(key, EvictExpired)
+		removed++
+	}
+
+	r.stats.expirations.Add(uint64(removed))
+
+	return removed
+}
+
+// write stores value, or a miss, according to mode and returns what it newly took and what happened.
+// Expired items queued by get, a replaced item and victims are removed on the way, so what is taken
+// can be negative. The write frees at least need of what it takes by them, evicting by policy
+// if there is one, or it is rejected as full.
+func (r *BaseCache[K, V]) write(key K, value V, takes usage, duration time.Duration, mode writeMode, need int64, miss bool) (usage, writeResult) {
+	if mode == modeAdd && r.batchSize <= 1 { // a pending batch may have deleted what front still shows
+		if shown := r.get(key); shown != nil && !shown.miss {
+			return usage{}, rejectedExists
+		}
+	}
+
+	now := r.clock.Now()
+	stored := &item[V]{
+		v:       value,
+		time:    now.Add(duration + r.staleWindow),
+		stale:   now.Add(duration),
+		created: now,
+		updated: now,
+		cost:    takes.cost,
+		miss:    miss,
+	}
+	if miss { // never served stale
+		stored.time = stored.stale
+	}
+
+	r.lockBack()
+	defer r.unlockBack()
+	defer r.commit(now)
+
+	before := r.used
+	r.drainExpired(now)
+
+	old, exists := (*r.back)[key]
+	live := exists && !old.expired(now) && !old.miss // a cached miss is no value to keep or to replace
+	if live && mode == modeAdd {
+		return r.used.sub(before), rejectedExists
+	}
+	if !live && mode == modeReplace {
+		return r.used.sub(before), rejectedAbsent
+	}
+
+	freed := before.sub(r.used)
+	if miss {
+		need -= freed.misses
+	} else {
+		need -= freed.cost
+	}
+	if !r.makeRoom(now, key, need, miss) {
+		return r.used.sub(before), rejectedFull
+	}
+
+	if live {
+		stored.created = old.created
+		stored.hits = atomic.LoadUint32(&old.hits)
+		stored.load = atomic.LoadInt64(&old.load)
+		r.notify(key, old, EvictReplaced)
+	} else if exists && old.expired(now) { // not a live miss replaced by a value
+		r.stats.expirations.Add(1)
+		r.notify(key, old, EvictExpired)
+	}
+
+	r.set(key, stored)
+	r.pushExpiry(key, stored)
+
+	return r.used.sub(before), written
+}
+
+// delete removes keys and returns how many of them it removed and what they took.
+func (r *BaseCache[K, V]) delete(keys []K) (int, usage) {
+	r.lockBack()
+	defer r.unlockBack()
+
+	before := r.used
+	deleted := 0
+	for _, key := range keys {
+		if _, ok := (*r.back)[key]; !ok {
+			continue
+		}
+
+		r.remove(key, EvictDeleted)
+		deleted++
+	}
+
+	if deleted == 0 {
+		return 0, usage{}
+	}
+
+	r.commit(r.clock.Now())
+	r.stats.deletes.Add(uint64(deleted))
+
+	return deleted, before.sub(r.used)
+}
+
+// refresh removes expired items, publishes any pending batch and returns what is left takes.
+// Its cost scales with the number of expired items, not with the bucket size.
+func (r *BaseCache[K, V]) refresh() usage {
+	now := r.clock.Now()
+
+	r.lockBack()
+	defer r.unlockBack()
+
+	r.drainExpired(now)
+	expired := r.popExpired(now)
+	r.stats.expirations.Add(uint64(expired))
+
+	r.flush()
+
+	return r.used
+}
+
+func (r *BaseCache[K, V]) lockBack() {
+	start := time.Now()
+	r.block.Lock()
+	r.stats.backWait.observe(time.Since(start))
+}
+
+// unlockBack releases block, then tells the eviction listeners about the removals published meanwhile.
+func (r *BaseCache[K, V]) unlockBack() {
+	evicted := r.published
+	r.published = nil
+	r.block.Unlock()
+
+	r.listeners.fire(evicted)
+}
+
+// swap publishes back as the new front and makes the old front the back once no reader is left on it.
+// block must be held, so there is only one swap at a time.
+func (r *BaseCache[K, V]) swap() {
+	start := time.Now()
+
+	r.back = r.front.Swap(r.back)
+
+	version := r.version.Load()
+	r.waitReaders(version + 1) // stragglers of the swap before, who only ever saw a front that is not the back
+	r.version.Store(version + 1)
+	r.waitReaders(version) // readers who may have loaded the old front
+
+	r.stats.swapWait.observe(time.Since(start))
+	r.stats.swaps.Add(1)
+}
+
+func (r *BaseCache[K, V]) waitReaders(version uint32) {
+	for r.readers[version&1].Load() != 0 {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,536 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	cache := NewCache[int, []byte](func(key int) uint { return uint(key) }, time.Hour, 10, 3, nil)
+
+	ret, ok := cache.Get(1)
+	assert.False(t, ok)
+	assert.Nil(t, ret)
+
+	assert.True(t, cache.Store(1, []byte("a"), time.Minute))
+	ret, ok = cache.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), ret)
+
+	assert.True(t, cache.Set(1, nil, time.Minute))
+	ret, ok = cache.Get(1) // a cached zero value is still a hit
+	assert.True(t, ok)
+	assert.Nil(t, ret)
+
+	assert.True(t, cache.Delete(1))
+	_, ok = cache.Get(1)
+	assert.False(t, ok)
+}
+
+func TestGetOrLoad(t *testing.T) {
+	cache := NewCache[int, string](func(key int) uint { return uint(key) }, time.Hour, 10, 3, nil)
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (string, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "a", time.Minute, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.GetOrLoad(context.Background(), 1, loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "a", v)
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	v, ok := cache.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+
+	failed := errors.New("failed")
+	_, err := cache.GetOrLoad(context.Background(), 2, func(ctx context.Context) (string, time.Duration, error) {
+		return "", time.Minute, failed
+	})
+	assert.Equal(t, failed, err)
+	_, ok = cache.Get(2)
+	assert.False(t, ok)
+}
+
+func TestStats(t *testing.T) {
+	cache := NewCache[int, string](func(key int) uint { return uint(key) }, time.Hour, 2, 2, nil)
+
+	cache.Get(1)
+	cache.Store(1, "a", time.Minute)
+	cache.Get(1)
+	cache.Store(1, "a", time.Minute)
+	cache.Replace(2, "b", time.Minute)
+	cache.Store(2, "b", time.Minute)
+	cache.Store(3, "c", time.Minute)
+	cache.Delete(2)
+
+	stats := cache.Stats()
+	assert.Equal(t, 1, stats.Items)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 0.5, stats.HitRatio())
+	assert.Equal(t, uint64(2), stats.Stores)
+	assert.Equal(t, uint64(1), stats.RejectedExists)
+	assert.Equal(t, uint64(1), stats.RejectedAbsent)
+	assert.Equal(t, uint64(1), stats.RejectedFull)
+	assert.Equal(t, uint64(1), stats.Deletes)
+	assert.Equal(t, 1, stats.Capacity)
+
+	assert.Equal(t, uint64(1), stats.Buckets[1].Hits)
+	assert.Equal(t, uint64(1), stats.Buckets[0].Deletes)
+}
+
+func TestRunClose(t *testing.T) {
+	cache := NewCache[int, string](func(key int) uint { return uint(key) }, time.Millisecond, 10, 2, nil)
+	assert.NoError(t, cache.Close()) // before Run
+	assert.Equal(t, ErrClosed, cache.Run(context.Background()))
+
+	cache = NewCache[int, string](func(key int) uint { return uint(key) }, time.Millisecond, 10, 2, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, cache.Run(ctx))
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- cache.Run(context.Background())
+	}()
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, ErrRunning, cache.Run(context.Background()))
+
+	assert.NoError(t, cache.Close())
+	assert.NoError(t, cache.Close())
+	assert.NoError(t, <-errs)
+	cache.Stop()
+}
+
+func TestRefreshByExpiry(t *testing.T) {
+	cache := NewCache[int, string](func(key int) uint { return 0 }, time.Hour, 10, 1, nil)
+
+	cache.Store(1, "a", time.Millisecond)
+	cache.Store(2, "b", time.Millisecond)
+	cache.Store(3, "c", time.Minute)
+	cache.Set(2, "b", time.Minute) // the first expiry of 2 is stale now
+	time.Sleep(2 * time.Millisecond)
+
+	cache.refresh()
+
+	stats := cache.Stats()
+	assert.Equal(t, 2, stats.Items)
+	assert.Equal(t, uint64(1), stats.Expirations)
+	assert.Equal(t, 8, stats.Capacity)
+	assert.Equal(t, 2, len(cache.caches[0].expiry)) // 1 and the stale entry of 2 were popped
+}
+
+func TestBatching(t *testing.T) {
+	cache := NewCache[int, string](func(key int) uint { return 0 }, time.Hour, 10, 1, nil)
+	cache.SetBatching(3, 0)
+
+	cache.Store(1, "a", time.Minute)
+	cache.Store(2, "b", time.Minute)
+	_, ok := cache.Get(1)
+	assert.False(t, ok) // pending
+	assert.False(t, cache.Add(1, "c", time.Minute))
+
+	cache.Delete(2)
+	_, ok = cache.Get(1) // the third write published the batch with one swap
+	assert.True(t, ok)
+	_, ok = cache.Get(2)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), cache.Stats().Swaps)
+
+	cache.Set(1, "d", time.Minute)
+	v, _ := cache.Get(1)
+	assert.Equal(t, "a", v)
+
+	cache.Flush()
+	v, _ = cache.Get(1)
+	assert.Equal(t, "d", v)
+
+	// after the swap the other map got every write replayed too
+	cache.Store(3, "e", time.Minute)
+	cache.Flush()
+	v, _ = cache.Get(1)
+	assert.Equal(t, "d", v)
+	_, ok = cache.Get(2)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(3), cache.Stats().Swaps)
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	cache := NewCache[int, int](func(key int) uint { return uint(key) }, time.Hour, 100, 4, nil)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				cache.Set(i%50, w, time.Minute)
+				if i%7 == 0 {
+					cache.Delete(i % 50)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				if v, ok := cache.Get(i % 50); ok {
+					assert.True(t, v >= 0 && v < 4)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDefaultHasher(t *testing.T) {
+	cache := NewCache[string, int](nil, time.Hour, 1000, 10, nil)
+
+	for i := 0; i < 1000; i++ {
+		cache.Store(fmt.Sprintf("Bearer %d", i), i, time.Minute)
+	}
+
+	distribution := cache.Stats().Distribution()
+	assert.Equal(t, 100.0, distribution.Mean)
+	assert.Less(t, distribution.Skew, 1.5)
+
+	// anagrams no longer share a bucket like they did with the byte sum
+	hash := NewHasher[string]()
+	assert.NotEqual(t, hash("Bearer ab"), hash("Bearer ba"))
+}
+
+type testRegistry map[string]StatsSource
+
+func (r testRegistry) Register(name string, source StatsSource) {
+	r[name] = source
+}
+
+func TestOptions(t *testing.T) {
+	_, err := NewCacheWithOptions[int, string](WithBuckets(0))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewCacheWithOptions[int, string](WithCapacity(-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewCacheWithOptions[int, string](WithHasher(func(key string) uint { return 0 }))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewWithOptions()
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewCacheWithOptions[int, string](WithStaleWhileRevalidate(time.Second)) // no loader
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewCacheWithOptions[int, string](WithRefreshAhead(0.2, 1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	loader := WithLoader[int, string](LoaderFunc[int, string](func(ctx context.Context, key int) (string, time.Duration, error) {
+		return "", 0, nil
+	}))
+	_, err = NewCacheWithOptions[int, string](loader, WithStaleIfError(time.Minute)) // no stale window
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	// a grace no longer than the stale window
+	_, err = NewCacheWithOptions[int, string](loader, WithStaleWhileRevalidate(time.Minute), WithStaleIfError(time.Minute))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewCacheWithOptions[int, string](loader, WithXFetch(1)) // no refresh-ahead
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	assert.Panics(t, func() {
+		NewCache[int, string](nil, time.Second, 10, 0, nil)
+	})
+
+	registry := testRegistry{}
+	cache, err := NewCacheWithOptions[int, string](
+		WithCapacity(2),
+		WithBuckets(1),
+		WithHasher(func(key int) uint { return uint(key) }),
+		WithEvictionPolicy(EvictFIFO),
+		WithMetrics("test", registry),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRefreshInterval, cache.refreshDuration)
+
+	cache.Store(1, "a", time.Minute)
+	cache.Store(2, "b", time.Minute)
+	cache.Store(3, "c", time.Minute)
+	assert.Equal(t, 2, registry["test"].Stats().Items)
+	assert.Equal(t, uint64(1), registry["test"].Stats().Evictions)
+
+	// a rejected cache is not registered
+	rejected := testRegistry{}
+	_, err = NewCacheWithOptions[int, string](WithMetrics("rejected", rejected), WithValueCodec[int](GobCodec[int]{}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewWithOptions(WithKeyString(func(key interface{}) string { return "" }), WithMetrics("rejected", rejected),
+		WithValueCodec[int](GobCodec[int]{}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	assert.Empty(t, rejected)
+}
+
+func TestOnEvict(t *testing.T) {
+	cache := NewCache[int, string](func(key int) uint { return 0 }, time.Hour, 2, 1, EvictFIFO)
+
+	evicted := map[int]EvictReason{}
+	cache.OnEvict(func(key int, value string, reason EvictReason) {
+		evicted[key] = reason
+		cache.Get(key) // runs outside the bucket lock
+	})
+
+	cache.Store(1, "a", time.Minute)
+	cache.Set(1, "b", time.Minute)
+	assert.Equal(t, map[int]EvictReason{1: EvictReplaced}, evicted)
+
+	cache.Store(2, "c", -time.Second)
+	cache.refresh()
+	assert.Equal(t, EvictExpired, evicted[2])
+
+	cache.Store(2, "d", time.Minute)
+	cache.Store(3, "e", time.Minute)
+	assert.Equal(t, EvictCapacity, evicted[1])
+
+	cache.Delete(3)
+	assert.Equal(t, EvictDeleted, evicted[3])
+
+	// a batched removal is told once it is published
+	delete(evicted, 2)
+	cache.SetBatching(10, 0)
+	cache.Delete(2)
+	assert.NotContains(t, evicted, 2)
+	cache.Flush()
+	assert.Equal(t, EvictDeleted, evicted[2])
+	assert.Equal(t, "deleted", EvictDeleted.String())
+}
+
+func TestMaxCost(t *testing.T) {
+	cache, err := NewCacheWithOptions[int, string](WithMaxCost(10), WithBuckets(1), WithHasher(func(key int) uint { return 0 }),
+		WithCost(func(value string) int64 { return int64(len(value)) }))
+	assert.NoError(t, err)
+
+	assert.True(t, cache.Store(1, "aaaa", time.Minute))
+	assert.True(t, cache.Store(2, "bbbb", time.Minute))
+	assert.False(t, cache.Store(3, "ccc", time.Minute)) // 8 of 10 taken
+	assert.True(t, cache.StoreWithCost(3, "ccc", 2, time.Minute))
+	assert.Equal(t, 0, cache.Stats().Capacity)
+
+	assert.False(t, cache.Set(4, "dddddddddddd", time.Minute)) // never fits
+	assert.True(t, cache.Replace(1, "aa", time.Minute))
+	assert.Equal(t, 2, cache.Stats().Capacity)
+
+	cache.Delete(2)
+	assert.Equal(t, 6, cache.Stats().Capacity)
+
+	evicting, _ := NewCacheWithOptions[int, string](WithMaxCost(10), WithBuckets(1), WithEvictionPolicy(EvictFIFO),
+		WithCost(func(value string) int64 { return int64(len(value)) }))
+	evicting.Store(1, "aaaa", time.Minute)
+	evicting.Store(2, "bbbb", time.Minute)
+	evicting.Store(3, "cc", time.Minute)
+	assert.True(t, evicting.Store(4, "dddddd", time.Minute)) // evicts 1 and 2
+	_, ok := evicting.Get(2)
+	assert.False(t, ok)
+	_, ok = evicting.Get(3)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), evicting.Stats().Evictions)
+	assert.Equal(t, 2, evicting.Stats().Capacity)
+
+	_, err = NewCacheWithOptions[int, string](WithCost(func(value []byte) int64 { return 0 }))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestNegative(t *testing.T) {
+	cache, err := NewCacheWithOptions[int, string](WithCapacity(2), WithMissCapacity(1), WithBuckets(1), WithEvictionPolicy(EvictFIFO))
+	assert.NoError(t, err)
+
+	_, presence := cache.Lookup(1)
+	assert.Equal(t, Unknown, presence)
+
+	assert.True(t, cache.StoreMiss(1, time.Minute))
+	_, presence = cache.Lookup(1)
+	assert.Equal(t, Absent, presence)
+	_, ok := cache.Get(1)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(2), cache.Stats().NegativeHits) // neither hits nor misses
+	assert.Equal(t, uint64(1), cache.Stats().Misses)
+
+	// misses take capacity of their own
+	assert.True(t, cache.Store(2, "b", time.Minute))
+	assert.True(t, cache.Store(3, "c", time.Minute))
+	assert.True(t, cache.StoreMiss(4, time.Minute)) // evicts the miss of 1, no value
+	_, presence = cache.Lookup(1)
+	assert.Equal(t, Unknown, presence)
+	_, presence = cache.Lookup(2)
+	assert.Equal(t, Present, presence)
+	assert.Equal(t, 0, cache.Stats().MissCapacity)
+
+	assert.False(t, cache.Replace(4, "d", time.Minute)) // a miss is no value to replace
+	assert.True(t, cache.Add(4, "d", time.Minute))
+	v, presence := cache.Lookup(4)
+	assert.Equal(t, Present, presence)
+	assert.Equal(t, "d", v)
+	assert.Equal(t, 1, cache.Stats().MissCapacity)
+	assert.Equal(t, uint64(0), cache.Stats().Expirations) // the miss was replaced, it did not expire
+
+	loads := 0
+	loader := func(ctx context.Context) (string, time.Duration, error) {
+		loads++
+		return "", time.Minute, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		_, err = cache.GetOrLoad(context.Background(), 5, loader)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, 1, loads)
+}
+
+func TestReadWriteThrough(t *testing.T) {
+	values := map[int]string{1: "a"}
+	fail := false
+	loader := LoaderFunc[int, string](func(ctx context.Context, key int) (string, time.Duration, error) {
+		v, ok := values[key]
+		if !ok {
+			return "", time.Minute, ErrNotFound
+		}
+		return v, time.Minute, nil
+	})
+	writer := WriterFunc[int, string](func(ctx context.Context, changes []Change[int, string]) error {
+		if fail {
+			return errors.New("backend down")
+		}
+		for _, change := range changes {
+			if change.Deleted {
+				delete(values, change.Key)
+			} else {
+				values[change.Key] = change.Value
+			}
+		}
+		return nil
+	})
+
+	cache, err := NewCacheWithOptions[int, string](WithLoader[int, string](loader), WithReadThrough(), WithWriter[int, string](writer))
+	assert.NoError(t, err)
+
+	v, ok := cache.Get(1) // read through
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+	_, ok = cache.Get(2)
+	assert.False(t, ok)
+	_, presence := cache.Lookup(2) // the miss is cached
+	assert.Equal(t, Absent, presence)
+
+	assert.True(t, cache.Set(2, "b", time.Minute))
+	assert.Equal(t, "b", values[2])
+	cache.Delete(1)
+	_, ok = values[1]
+	assert.False(t, ok)
+
+	fail = true
+	assert.False(t, cache.Set(3, "c", time.Minute))
+	_, presence = cache.Lookup(3) // not kept, the store does not have it
+	assert.Equal(t, Unknown, presence)
+	assert.Equal(t, uint64(1), cache.Stats().WriteErrors)
+
+	_, err = NewCacheWithOptions[int, string](WithReadThrough())
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewCacheWithOptions[int, string](WithWriteBehind(10, time.Second, 3))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestWriteThroughOrder(t *testing.T) {
+	var lock sync.Mutex
+	stored := map[int]string{}
+	writer := WriterFunc[int, string](func(ctx context.Context, changes []Change[int, string]) error {
+		time.Sleep(time.Microsecond) // let concurrent writes overtake each other if they can
+		lock.Lock()
+		defer lock.Unlock()
+		for _, change := range changes {
+			stored[change.Key] = change.Value
+		}
+		return nil
+	})
+
+	cache, err := NewCacheWithOptions[int, string](WithWriter[int, string](writer))
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Set(1, fmt.Sprint(i), time.Minute)
+		}(i)
+	}
+	wg.Wait()
+
+	v, _ := cache.Get(1)
+	assert.Equal(t, stored[1], v) // the store ends with what the cache ends with
+}
+
+func TestWriteTimeout(t *testing.T) {
+	hung := WriterFunc[int, string](func(ctx context.Context, changes []Change[int, string]) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	cache, err := NewCacheWithOptions[int, string](WithWriter[int, string](hung), WithWriteTimeout(10*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, cache.Set(1, "a", time.Minute))
+	assert.Equal(t, uint64(1), cache.Stats().WriteErrors)
+
+	behind, err := NewCacheWithOptions[int, string](WithWriter[int, string](hung), WithWriteTimeout(10*time.Millisecond),
+		WithWriteBehind(10, time.Second, 3))
+	assert.NoError(t, err)
+	assert.True(t, behind.Set(1, "a", time.Minute))
+	behind.Close() // does not hang
+	assert.Equal(t, uint64(1), behind.Stats().WritesDropped)
+
+	_, err = NewCacheWithOptions[int, string](WithWriteTimeout(-time.Second))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestMaxCostBuckets(t *testing.T) {
+	cache, err := NewCacheWithOptions[int, string](WithMaxCost(100), WithBuckets(10), WithEvictionPolicy(EvictLRU),
+		WithCost(func(value string) int64 { return 30 }))
+	assert.NoError(t, err)
+
+	// the budget is shared by all buckets, a full bucket of key evicts from the others
+	for i := 0; i < 50; i++ {
+		assert.True(t, cache.Set(i, "v", time.Minute), "write %d", i)
+	}
+
+	stats := cache.Stats()
+	assert.Equal(t, 3, stats.Items)
+	assert.Equal(t, 10, stats.Capacity)
+	assert.Equal(t, uint64(47), stats.Evictions)
+	_, ok := cache.Get(49)
+	assert.True(t, ok)
+}
+
+func BenchmarkSetFull(b *testing.B) {
+	const capacity = 100000
+	cache, err := NewCacheWithOptions[int, string](WithCapacity(capacity), WithBuckets(10), WithEvictionPolicy(EvictLRU))
+	assert.NoError(b, err)
+	for i := 0; i < capacity; i++ {
+		cache.Set(i, "v", time.Hour)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(capacity+i, "v", time.Hour)
+	}
+}
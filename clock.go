@@ -0,0 +1,36 @@
+package gocache
+
+import (
+	"time"
+)
+
+// Clock tells the cache the time. Item expiry, batch delays and the tickers of Run follow it,
+// so a fake clock such as gocachetest.FakeClock makes them deterministic in tests.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the cache uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (r systemTicker) C() <-chan time.Time {
+	return r.Ticker.C
+}
@@ -0,0 +1,45 @@
+package gocache_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/philolight/gocache"
+	"github.com/philolight/gocache/gocachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCacheTTL(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+	cache := gocache.NewExampleCache(gocache.WithClock(clock))
+	defer cache.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "test", nil)
+	req.Header.Set("Authorization", "Bearer denied")
+	cache.Store(req, &http.Response{StatusCode: http.StatusUnauthorized})
+
+	clock.Advance(59 * time.Second)
+	assert.NotNil(t, cache.Get(req))
+
+	clock.Advance(2 * time.Second)
+	assert.Nil(t, cache.Get(req))
+}
+
+func TestFakeClockRefresh(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+	cache, _ := gocache.NewCacheWithOptions[int, string](gocache.WithClock(clock), gocache.WithRefreshInterval(time.Second))
+
+	go cache.Run(context.Background())
+	defer cache.Close()
+	clock.BlockUntil(1)
+
+	cache.Store(1, "a", time.Minute)
+	clock.Advance(time.Minute + time.Second)
+
+	assert.Eventually(t, func() bool {
+		return cache.Stats().Expirations == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, cache.Stats().Items)
+}
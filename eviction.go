@@ -0,0 +1,280 @@
+package gocache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EvictReason tells eviction listeners why an item left the cache.
+type EvictReason int
+
+const (
+	EvictExpired  EvictReason = iota + 1 // its TTL passed
+	EvictCapacity                        // it made room for a new key of a full cache
+	EvictDeleted                         // Delete or DeleteMany removed it
+	EvictReplaced                        // Set or Replace stored another value for its key
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictDeleted:
+		return "deleted"
+	case EvictReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// OnEvict registers fn to be called for every item leaving the cache, e.g. to close resources
+// held by values. fn runs after the bucket locks are released, on the goroutine of the write, refresh
+// or Delete that removed the item, once readers can not find the item anymore. A reader that found it
+// before may still hold the value though. With WithWriter, fn must not write to the cache, writes of
+// keys to the store are serialized and fn runs while the write that removed the item holds its lock.
+func (r *Cache[K, V]) OnEvict(fn func(key K, value V, reason EvictReason)) {
+	r.listeners.add(fn)
+}
+
+type eviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// evictListeners is copy on write, so removals check for listeners without a lock.
+type evictListeners[K comparable, V any] struct {
+	lock sync.Mutex
+	fns  atomic.Pointer[[]func(key K, value V, reason EvictReason)]
+}
+
+func (r *evictListeners[K, V]) add(fn func(key K, value V, reason EvictReason)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var fns []func(key K, value V, reason EvictReason)
+	if old := r.fns.Load(); old != nil {
+		fns = append(fns, *old...)
+	}
+	fns = append(fns, fn)
+	r.fns.Store(&fns)
+}
+
+func (r *evictListeners[K, V]) active() bool {
+	fns := r.fns.Load()
+	return fns != nil && len(*fns) > 0
+}
+
+func (r *evictListeners[K, V]) fire(evicted []eviction[K, V]) {
+	if len(evicted) == 0 {
+		return
+	}
+
+	fns := *r.fns.Load()
+	for _, e := range evicted {
+		for _, fn := range fns {
+			fn(e.key, e.value, e.reason)
+		}
+	}
+}
+
+// notify remembers stored left the cache, if anyone listens. Misses hold no value to tell about. block must be held.
+func (r *BaseCache[K, V]) notify(key K, stored *item[V], reason EvictReason) {
+	if stored == nil || stored.miss || !r.listeners.active() {
+		return
+	}
+
+	r.evicted = append(r.evicted, eviction[K, V]{key: key, value: stored.v, reason: reason})
+}
+
+// Entry describes a cached item to an EvictionPolicy.
+type Entry struct {
+	Created  time.Time // when the key was first stored
+	Accessed time.Time // last hit, zero if never read
+	Expire   time.Time
+	Hits     uint32
+}
+
+// EvictionPolicy picks which item of a full bucket makes room for a new one. The cache
+// does not keep its items ordered but evicts the least of a random sample of them, so on a large bucket
+// the policy is approximated. Expired items of the sample are evicted first, the policy only orders live ones.
+type EvictionPolicy interface {
+	// Less reports whether a should be evicted before b.
+	Less(a, b *Entry) bool
+}
+
+var (
+	// EvictLRU evicts the least recently read item.
+	EvictLRU EvictionPolicy = lruPolicy{}
+	// EvictLFU evicts the least frequently read item.
+	EvictLFU EvictionPolicy = lfuPolicy{}
+	// EvictFIFO evicts the oldest stored item.
+	EvictFIFO EvictionPolicy = fifoPolicy{}
+	// EvictRandom evicts an arbitrary item.
+	EvictRandom EvictionPolicy = randomPolicy{}
+)
+
+type lruPolicy struct{}
+
+func (lruPolicy) Less(a, b *Entry) bool {
+	if a.Accessed.Equal(b.Accessed) {
+		return a.Created.Before(b.Created)
+	}
+	return a.Accessed.Before(b.Accessed)
+}
+
+type lfuPolicy struct{}
+
+func (lfuPolicy) Less(a, b *Entry) bool {
+	if a.Hits == b.Hits {
+		return a.Accessed.Before(b.Accessed)
+	}
+	return a.Hits < b.Hits
+}
+
+type fifoPolicy struct{}
+
+func (fifoPolicy) Less(a, b *Entry) bool {
+	return a.Created.Before(b.Created)
+}
+
+type randomPolicy struct{}
+
+// Less never reorders, so the victim is the first item visited and map iteration order is already random.
+func (randomPolicy) Less(a, b *Entry) bool {
+	return false
+}
+
+// evictionSamples is how many candidates victim compares to pick one.
+const evictionSamples = 16
+
+// touch records a hit. Items are shared by front and back, so this needs no bucket lock.
+func (r *item[V]) touch(now time.Time) {
+	atomic.StoreInt64(&r.accessed, now.UnixNano())
+	atomic.AddUint32(&r.hits, 1)
+}
+
+func (r *item[V]) entry(e *Entry) {
+	e.Created = r.created
+	e.Accessed = time.Time{}
+	if accessed := atomic.LoadInt64(&r.accessed); accessed != 0 {
+		e.Accessed = time.Unix(0, accessed)
+	}
+	e.Expire = r.time
+	e.Hits = atomic.LoadUint32(&r.hits)
+}
+
+// makeRoom frees need for a write of key, counting what replacing key frees, by evicting victims.
+// need is cost for values and a number of misses for misses, which only evict misses.
+// It reports whether it freed enough and evicts nothing if it can not. block must be held.
+func (r *BaseCache[K, V]) makeRoom(now time.Time, key K, need int64, miss bool) bool {
+	evictable := r.used.of(miss)
+	if old, ok := (*r.back)[key]; ok {
+		need -= old.usage().of(miss)
+		evictable -= old.usage().of(miss)
+	}
+
+	if need <= 0 {
+		return true
+	}
+	if r.policy == nil || evictable < need {
+		return false
+	}
+
+	for need > 0 {
+		victim, ok := r.victim(now, &key, miss)
+		if !ok {
+			return false
+		}
+
+		need -= (*r.back)[victim].usage().of(miss)
+		r.remove(victim, EvictCapacity)
+		r.stats.evictions.Add(1)
+	}
+
+	return true
+}
+
+// evictOthers evicts from the buckets other than idx until they freed need, or have nothing left to evict,
+// and returns what they freed. It is the fallback of a write whose own bucket can not free need,
+// since the cost budget of WithMaxCost and the misses of WithMissCapacity are shared by all buckets.
+func (r *Cache[K, V]) evictOthers(idx uint, need int64, miss bool) usage {
+	var freed usage
+
+	start := uint(r.evictFrom.Add(1))
+	for i := 0; i < r.bucketSize && freed.of(miss) < need; i++ {
+		other := (start + uint(i)) % uint(r.bucketSize)
+		if other == idx {
+			continue
+		}
+
+		freed = freed.add(r.caches[other].evict(need-freed.of(miss), miss))
+	}
+
+	return freed
+}
+
+// evict removes victims by policy, among misses or values, until they freed need or none is left,
+// and returns what they freed.
+func (r *BaseCache[K, V]) evict(need int64, miss bool) usage {
+	now := r.clock.Now()
+
+	r.lockBack()
+	defer r.unlockBack()
+
+	before := r.used
+	for before.sub(r.used).of(miss) < need {
+		victim, ok := r.victim(now, nil, miss)
+		if !ok {
+			break
+		}
+
+		r.remove(victim, EvictCapacity)
+		r.stats.evictions.Add(1)
+	}
+
+	freed := before.sub(r.used)
+	if freed != (usage{}) {
+		r.commit(now)
+	}
+
+	return freed
+}
+
+// victim chooses the key to evict from the back map, never except if it is not nil, among misses or values.
+// It samples evictionSamples candidates, map iteration starts at a random item, instead of scanning
+// the whole bucket for every eviction, so a bucket of up to evictionSamples candidates is ordered exactly.
+// block must be held.
+func (r *BaseCache[K, V]) victim(now time.Time, except *K, miss bool) (K, bool) {
+	var (
+		victimKey K
+		found     bool
+		victim    Entry
+		candidate Entry
+		sampled   int
+	)
+
+	for k, v := range *r.back {
+		if (except != nil && k == *except) || v.miss != miss {
+			continue
+		}
+		if sampled == evictionSamples {
+			break
+		}
+		sampled++
+
+		if v.expired(now) {
+			return k, true
+		}
+
+		v.entry(&candidate)
+		if !found || r.policy.Less(&candidate, &victim) {
+			victimKey, victim, found = k, candidate, true
+		}
+	}
+
+	return victimKey, found
+}
@@ -0,0 +1,67 @@
+package gocache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryHeap is a min-heap of items by expiry, so refresh only touches items that actually expired.
+// Entries of items replaced or removed since they were pushed are stale and skipped when popped.
+type expiryHeap[K comparable, V any] []expiryEntry[K, V]
+
+type expiryEntry[K comparable, V any] struct {
+	key  K
+	item *item[V]
+}
+
+func (r expiryHeap[K, V]) Len() int           { return len(r) }
+func (r expiryHeap[K, V]) Less(i, j int) bool { return r[i].item.time.Before(r[j].item.time) }
+func (r expiryHeap[K, V]) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (r *expiryHeap[K, V]) Push(x any) {
+	*r = append(*r, x.(expiryEntry[K, V]))
+}
+
+func (r *expiryHeap[K, V]) Pop() any {
+	old := *r
+	n := len(old)
+	ret := old[n-1]
+	old[n-1] = expiryEntry[K, V]{}
+	*r = old[:n-1]
+	return ret
+}
+
+// pushExpiry tracks the expiry of stored. block must be held.
+func (r *BaseCache[K, V]) pushExpiry(key K, stored *item[V]) {
+	heap.Push(&r.expiry, expiryEntry[K, V]{key: key, item: stored})
+
+	// stale entries of long lived items pile up when they are overwritten often, rebuild from the live ones
+	if len(r.expiry) > 2*len(*r.back)+r.keyBufferSize+64 {
+		r.rebuildExpiry()
+	}
+}
+
+func (r *BaseCache[K, V]) rebuildExpiry() {
+	r.expiry = make(expiryHeap[K, V], 0, len(*r.back))
+	for k, v := range *r.back {
+		r.expiry = append(r.expiry, expiryEntry[K, V]{key: k, item: v})
+	}
+	heap.Init(&r.expiry)
+}
+
+// popExpired removes the items expired at now and returns how many it removed. block must be held.
+func (r *BaseCache[K, V]) popExpired(now time.Time) int {
+	removed := 0
+
+	for len(r.expiry) > 0 && r.expiry[0].item.expired(now) {
+		e := heap.Pop(&r.expiry).(expiryEntry[K, V])
+		if (*r.back)[e.key] != e.item {
+			continue
+		}
+
+		r.remove(e.key, EvictExpired)
+		removed++
+	}
+
+	return removed
+}
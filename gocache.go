@@ -1,196 +1,204 @@
 package gocache
 
 import (
-	"sync"
-	"sync/atomic"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"time"
 )
 
 type Cacher interface {
+	Run(ctx context.Context) error
+	Close() error
 	Start()
 	Get(key interface{}) interface{}
+	Lookup(key interface{}) (interface{}, Presence)
+	LookupWithTTL(key interface{}) (interface{}, Presence, time.Duration)
+	Load(ctx context.Context, key interface{}) (interface{}, error)
 	Store(key interface{}, value interface{}, duration time.Duration) bool
+	StoreWithCost(key interface{}, value interface{}, cost int64, duration time.Duration) bool
+	Set(key interface{}, value interface{}, duration time.Duration) bool
+	SetWithCost(key interface{}, value interface{}, cost int64, duration time.Duration) bool
+	Add(key interface{}, value interface{}, duration time.Duration) bool
+	Replace(key interface{}, value interface{}, duration time.Duration) bool
+	StoreMiss(key interface{}, ttl time.Duration) bool
+	Delete(key interface{}) bool
+	DeleteMany(keys ...interface{}) int
+	Stats() CacheStats
+	OnEvict(fn func(key string, value interface{}, reason EvictReason))
+	SaveSnapshot(w io.Writer) error
+	LoadSnapshot(r io.Reader) (int, error)
 	Stop()
 }
 
-// gocache factory method
+// gocache factory method, NewWithOptions validates its arguments instead of panicking.
 // parameters
-// - shortKeyString func(key interface{}) uint : generating function index of buckets
+// - shortKeyString func(key interface{}) uint : generating function index of buckets, nil hashes keyString with a seeded maphash
 // - keyString func(key interface{}) string : generating function key of cache as a string
 // - refreshDuration time.Duration : refresh duration of caches remove too old to keep(ex. this is time.Second, gocache calls gocache.refresh() every seconds)
 // - size int : limit number of cached instance(ex. size = 100, gocache contains 100 cached item as a maximum)
 // - bucketSize int : a number of separated caches (to avoid lock) (ex. bucketSize is 10, drop down the probability of locking to 1/10)
 func New(shortKeyString func(key interface{}) uint, keyString func(key interface{}) string, refreshDuration time.Duration, size int, bucketSize int) Cacher {
-	ret := &bucketCache{
-		caches:          make([]BaseCache, bucketSize, bucketSize),
-		size:            int32(size),
-		bucketSize:      bucketSize,
-		capacity:        int32(size),
-		shortKeyString:  shortKeyString,
-		refreshDuration: refreshDuration,
-		stop:            make(chan struct{}, 1),
-	}
+	return NewEvicting(shortKeyString, keyString, refreshDuration, size, bucketSize, nil)
+}
 
-	for i := 0; i < ret.bucketSize; i++ {
-		front := make(map[string]*item, size/ret.bucketSize)
-		back := make(map[string]*item, size/ret.bucketSize)
+// NewEvicting is New with an eviction policy. When the cache is full, a new key evicts an item chosen by policy
+// from its own bucket instead of being rejected. A nil policy rejects new keys like New does.
+func NewEvicting(shortKeyString func(key interface{}) uint, keyString func(key interface{}) string, refreshDuration time.Duration, size int, bucketSize int, policy EvictionPolicy) Cacher {
+	opts := []Option{WithKeyString(keyString), WithRefreshInterval(refreshDuration), WithCapacity(size), WithBuckets(bucketSize), WithEvictionPolicy(policy)}
+	if shortKeyString != nil {
+		opts = append(opts, WithHasher(shortKeyString))
+	}
 
-		ret.caches[i] = BaseCache{
-			keyString:     keyString,
-			front:         &front,
-			back:          &back,
-			keyBufferSize: size / ret.bucketSize,
-		}
+	ret, err := NewWithOptions(opts...)
+	if err != nil {
+		panic(err)
 	}
 
 	return ret
 }
 
-type item struct {
-	v    interface{}
-	time time.Time
+// cacherKey is the key of the Cache behind a Cacher. short is derived from key, so it never changes equality.
+type cacherKey struct {
+	key   string
+	short uint
 }
 
-type bucketCache struct {
-	caches         []BaseCache
-	size           int32
-	bucketSize     int
-	capacity       int32
-	shortKeyString func(key interface{}) uint
-	stop           chan struct{}
-
-	refreshDuration time.Duration
+func (r cacherKey) bucketHash() uint {
+	return r.short
 }
 
-func (r *bucketCache) Start() {
-	for {
-		select {
-		case <-r.stop:
-			return
-		case <-time.After(r.refreshDuration):
-			var sum int32
-			for i := 0; i < int(r.bucketSize); i++ {
-				sum += r.caches[i].refresh()
-			}
-
-			atomic.StoreInt32(&r.capacity, r.size-sum)
-		}
-	}
+// cacherKeyCodec keeps short in snapshots, since a custom shortKeyString needs the original key.
+// A seeded hash differs every run, so it is recomputed by hash instead.
+type cacherKeyCodec struct {
+	hash func(key string) uint // nil for a custom shortKeyString
 }
 
-func (r *bucketCache) Stop() {
-	r.stop <- struct{}{}
+func (r cacherKeyCodec) Encode(key cacherKey) ([]byte, error) {
+	ret := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(key.key)), uint64(key.short))
+	return append(ret, key.key...), nil
 }
 
-func (r *bucketCache) Get(key interface{}) interface{} {
-	idx := r.getBucketIndex(key)
-
-	stored := r.caches[idx].get(key)
+func (r cacherKeyCodec) Decode(data []byte) (cacherKey, error) {
+	short, n := binary.Uvarint(data)
+	if n <= 0 {
+		return cacherKey{}, fmt.Errorf("%w: bad key", ErrSnapshotCorrupt)
+	}
 
-	if stored == nil {
-		return nil
+	ret := cacherKey{key: string(data[n:]), short: uint(short)}
+	if r.hash != nil {
+		ret.short = r.hash(ret.key)
 	}
 
-	return stored.v
+	return ret, nil
 }
 
-func (r *bucketCache) getBucketIndex(key interface{}) uint {
-	return r.shortKeyString(key) % uint(r.bucketSize)
+// bucketCache adapts Cache to the interface{} keyed Cacher.
+type bucketCache struct {
+	cache          *Cache[cacherKey, interface{}]
+	shortKeyString func(key interface{}) uint
+	keyString      func(key interface{}) string
+	hashKeyString  func(key string) uint // used when shortKeyString is nil
 }
 
-func (r *bucketCache) Store(key interface{}, value interface{}, duration time.Duration) bool {
-	if atomic.LoadInt32(&r.capacity) <= 0 {
-		return false
+func (r *bucketCache) key(key interface{}) cacherKey {
+	keyString := r.keyString(key)
+	if r.shortKeyString == nil {
+		return cacherKey{key: keyString, short: r.hashKeyString(keyString)}
 	}
 
-	atomic.AddInt32(&r.capacity, -1)
-
-	idx := r.getBucketIndex(key)
+	return cacherKey{key: keyString, short: r.shortKeyString(key)}
+}
 
-	delta := r.caches[idx].store(key, value, duration) - 1
+func (r *bucketCache) Run(ctx context.Context) error {
+	return r.cache.Run(ctx)
+}
 
-	atomic.AddInt32(&r.capacity, delta)
+func (r *bucketCache) Close() error {
+	return r.cache.Close()
+}
 
-	return delta == 0
+func (r *bucketCache) Start() {
+	r.cache.Start()
 }
 
-type BaseCache struct {
-	front         *map[string]*item            // map to read.
-	back          *map[string]*item            // map to back write. write back -> swap with front -> write back again
-	flock         sync.RWMutex                 // lock for front
-	block         sync.RWMutex                 // lock for back
-	keyString     func(key interface{}) string // make key string from request
-	keyBufferSize int
+func (r *bucketCache) Stop() {
+	r.cache.Stop()
 }
 
-func (r *BaseCache) get(key interface{}) *item {
-	keyString := r.keyString(key)
+func (r *bucketCache) Get(key interface{}) interface{} {
+	stored, _ := r.cache.Get(r.key(key))
+	return stored
+}
 
-	r.flock.RLock()
-	defer func() {
-		r.flock.RUnlock()
-	}()
-	return (*r.front)[keyString]
+func (r *bucketCache) Lookup(key interface{}) (interface{}, Presence) {
+	return r.cache.Lookup(r.key(key))
 }
 
-func (r *BaseCache) store(key interface{}, value interface{}, duration time.Duration) int32 {
-	keyString := r.keyString(key)
+func (r *bucketCache) LookupWithTTL(key interface{}) (interface{}, Presence, time.Duration) {
+	return r.cache.LookupWithTTL(r.key(key))
+}
 
-	if r.get(key) != nil {
-		return 0
-	}
+func (r *bucketCache) Load(ctx context.Context, key interface{}) (interface{}, error) {
+	return r.cache.Load(ctx, r.key(key))
+}
 
-	stored := &item{
-		v:    value,
-		time: time.Now().Add(duration),
-	}
+func (r *bucketCache) Store(key interface{}, value interface{}, duration time.Duration) bool {
+	return r.cache.Store(r.key(key), value, duration)
+}
 
-	r.block.Lock()
-	(*r.back)[keyString] = stored
+func (r *bucketCache) StoreWithCost(key interface{}, value interface{}, cost int64, duration time.Duration) bool {
+	return r.cache.StoreWithCost(r.key(key), value, cost, duration)
+}
 
-	r.swap()
+func (r *bucketCache) Set(key interface{}, value interface{}, duration time.Duration) bool {
+	return r.cache.Set(r.key(key), value, duration)
+}
 
-	(*r.back)[keyString] = stored
-	r.block.Unlock()
+func (r *bucketCache) SetWithCost(key interface{}, value interface{}, cost int64, duration time.Duration) bool {
+	return r.cache.SetWithCost(r.key(key), value, cost, duration)
+}
 
-	return 1
+func (r *bucketCache) Add(key interface{}, value interface{}, duration time.Duration) bool {
+	return r.cache.Add(r.key(key), value, duration)
 }
 
-func (r *BaseCache) refresh() int32 {
-	now := time.Now()
+func (r *bucketCache) Replace(key interface{}, value interface{}, duration time.Duration) bool {
+	return r.cache.Replace(r.key(key), value, duration)
+}
 
-	keys := make([]string, 0, r.keyBufferSize)
+func (r *bucketCache) StoreMiss(key interface{}, ttl time.Duration) bool {
+	return r.cache.StoreMiss(r.key(key), ttl)
+}
 
-	r.block.RLock()
-	for k, v := range *r.back {
-		if now.After(v.time) {
-			keys = append(keys, k)
-		}
-	}
-	r.block.RUnlock()
+func (r *bucketCache) Delete(key interface{}) bool {
+	return r.cache.Delete(r.key(key))
+}
 
-	r.block.Lock()
-	for _, key := range keys {
-		delete(*r.back, key)
+func (r *bucketCache) DeleteMany(keys ...interface{}) int {
+	converted := make([]cacherKey, len(keys))
+	for i, key := range keys {
+		converted[i] = r.key(key)
 	}
 
-	r.swap()
+	return r.cache.DeleteMany(converted...)
+}
 
-	for _, key := range keys {
-		delete(*r.back, key)
-	}
-	r.block.Unlock()
+func (r *bucketCache) Stats() CacheStats {
+	return r.cache.Stats()
+}
 
-	return int32(len(*r.back))
+func (r *bucketCache) SaveSnapshot(w io.Writer) error {
+	return r.cache.SaveSnapshot(w)
 }
 
-func (r *BaseCache) swap() {
-	r.flock.Lock()
-	defer func() {
-		r.flock.Unlock()
-	}()
+func (r *bucketCache) LoadSnapshot(rd io.Reader) (int, error) {
+	return r.cache.LoadSnapshot(rd)
+}
 
-	temp := r.front
-	r.front = r.back
-	r.back = temp
-}
\ No newline at end of file
+func (r *bucketCache) OnEvict(fn func(key string, value interface{}, reason EvictReason)) {
+	r.cache.OnEvict(func(key cacherKey, value interface{}, reason EvictReason) {
+		fn(key.key, value, reason)
+	})
+}
@@ -1,6 +1,10 @@
 package gocache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"time"
 )
@@ -9,22 +13,75 @@ const(
 	exampleCacheSize = 10000
 	exampleBucketSize = 100
 	exampleRefreshDuration = time.Second
+	verifiedDuration = time.Second * 5
+	deniedDuration = time.Minute
 )
 
 type VerifyCache struct {
-	cacher Cacher
+	cache *Cache[string, *verified]
 }
 
-func NewExampleCache() *VerifyCache {
-	ret := &VerifyCache{}
-	ret.cacher = New(ret.shortKey, ret.key, exampleRefreshDuration, exampleCacheSize, exampleBucketSize)
+// verified is a verify response with its body read, so every hit gets a body of its own.
+type verified struct {
+	resp *http.Response
+	body []byte
+}
+
+// newVerified reads the body of resp and gives resp a new one, so its caller can still read it.
+func newVerified(resp *http.Response) (*verified, error) {
+	ret := &verified{resp: new(http.Response)}
+	*ret.resp = *resp
+	ret.resp.Header = resp.Header.Clone()
+
+	if resp.Body != nil {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		ret.body = body
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return ret, nil
+}
+
+func (r *verified) response() *http.Response {
+	ret := new(http.Response)
+	*ret = *r.resp
+	ret.Header = r.resp.Header.Clone()
+	ret.Body = io.NopCloser(bytes.NewReader(r.body))
+
+	return ret
+}
+
+// NewExampleCache returns a started VerifyCache, opts override its defaults, e.g. WithClock in tests.
+func NewExampleCache(opts ...Option) *VerifyCache {
+	defaults := []Option{WithCapacity(exampleCacheSize), WithBuckets(exampleBucketSize), WithRefreshInterval(exampleRefreshDuration)}
+
+	cache, err := NewCacheWithOptions[string, *verified](append(defaults, opts...)...)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &VerifyCache{cache: cache}
 	ret.start()
 
 	return ret
 }
 
 func (r *VerifyCache) start() {
-	go r.cacher.Start()
+	go r.cache.Run(context.Background())
+}
+
+// Close stops refreshing the cache.
+func (r *VerifyCache) Close() {
+	if r == nil { // null object pattern
+		return
+	}
+
+	r.cache.Close()
 }
 
 func (r *VerifyCache) Get(req *http.Request) *http.Response {
@@ -32,12 +89,14 @@ func (r *VerifyCache) Get(req *http.Request) *http.Response {
 		return nil
 	}
 
-	stored, ok := r.cacher.Get(req).(*http.Response)
-	if !ok {
+	switch stored, presence := r.cache.Lookup(r.key(req)); presence {
+	case Present:
+		return stored.response()
+	case Absent:
+		return denied(req)
+	default:
 		return nil
 	}
-
-	return stored
 }
 
 func (r *VerifyCache) Store(req *http.Request, resp *http.Response) {
@@ -45,38 +104,78 @@ func (r *VerifyCache) Store(req *http.Request, resp *http.Response) {
 		return
 	}
 
-	r.cacher.Store(req, resp, r.cacheDuration(resp.StatusCode))
-}
+	if resp.StatusCode == http.StatusUnauthorized {
+		r.cache.StoreMiss(r.key(req), deniedDuration)
+		return
+	}
 
-func (r *VerifyCache) shortKey(key interface{}) uint {
-	req, ok := key.(*http.Request)
-	if !ok {
-		return 0
+	stored, err := newVerified(resp)
+	if err != nil { // not cached, the next request verifies again
+		return
 	}
-	keyBytes := []byte(req.Header.Get("Authorization"))
 
-	len := len(keyBytes)
+	r.cache.Set(r.key(req), stored, verifiedDuration)
+}
 
-	var sum uint
-	for i := 0; i < len; i++ {
-		sum += uint(keyBytes[i])
+// GetOrVerify returns the cached response of req's token, or calls verify once for all concurrent requests
+// carrying the same token and caches its response. A denied token gets a bare 401 Unauthorized response.
+func (r *VerifyCache) GetOrVerify(ctx context.Context, req *http.Request, verify func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+	if r == nil { // null object pattern
+		return verify(ctx)
 	}
 
-	return sum
+	stored, err := r.cache.GetOrLoad(ctx, r.key(req), func(ctx context.Context) (*verified, time.Duration, error) {
+		resp, err := verify(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			if resp.Body != nil {
+				resp.Body.Close()
+			}
+			return nil, deniedDuration, ErrNotFound
+		}
+
+		stored, err := newVerified(resp)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return stored, verifiedDuration, nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return denied(req), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return stored.response(), nil
 }
 
-func (r *VerifyCache) key(key interface{}) string {
-	req, ok := key.(*http.Request)
-	if !ok {
-		return ""
+// Delete evicts req's token at once, e.g. when it is revoked.
+func (r *VerifyCache) Delete(req *http.Request) {
+	if r == nil { // null object pattern
+		return
 	}
+
+	r.cache.Delete(r.key(req))
+}
+
+func (r *VerifyCache) key(req *http.Request) string {
 	return req.Header.Get("Authorization")
 }
 
-func (r *VerifyCache) cacheDuration(statusCode int) time.Duration {
-	if statusCode == http.StatusUnauthorized {
-		return time.Minute
+// denied is the response to req for a token whose denial is cached.
+func denied(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "401 Unauthorized",
+		StatusCode: http.StatusUnauthorized,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
 	}
-
-	return time.Second * 5
 }
@@ -1,7 +1,10 @@
 package gocache
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,6 +12,7 @@ import (
 
 func TestVerifyCache(t *testing.T) {
 	cache := NewExampleCache()
+	defer cache.Close()
 
 	req, _ := http.NewRequest(http.MethodGet, "test", nil)
 	req.Header.Set("Authorization", "Bearer test_token")
@@ -24,4 +28,40 @@ func TestVerifyCache(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer test_token2")
 	ret = cache.Get(req)
 	assert.Nil(t, ret)
-}
\ No newline at end of file
+}
+
+func TestVerifyCacheBody(t *testing.T) {
+	cache := NewExampleCache()
+	defer cache.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "test", nil)
+	req.Header.Set("Authorization", "Bearer test_token")
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("verified"))}
+
+	cache.Store(req, resp)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "verified", string(body))
+
+	for i := 0; i < 2; i++ { // every hit reads a body of its own
+		body, _ = io.ReadAll(cache.Get(req).Body)
+		assert.Equal(t, "verified", string(body))
+	}
+}
+
+func TestVerifyCacheDenied(t *testing.T) {
+	cache := NewExampleCache()
+	defer cache.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "test", nil)
+	req.Header.Set("Authorization", "Bearer revoked")
+
+	cache.Store(req, &http.Response{StatusCode: http.StatusUnauthorized})
+	assert.Equal(t, http.StatusUnauthorized, cache.Get(req).StatusCode)
+
+	resp, err := cache.GetOrVerify(context.Background(), req, func(ctx context.Context) (*http.Response, error) {
+		t.Fatal("a cached denial verifies again")
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,108 @@
+package gocache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testShortKey(key interface{}) uint {
+	n, _ := strconv.Atoi(key.(string))
+	return uint(n)
+}
+
+func testKey(key interface{}) string {
+	return key.(string)
+}
+
+func TestDelete(t *testing.T) {
+	cache := New(testShortKey, testKey, time.Hour, 2, 2).(*bucketCache)
+
+	assert.True(t, cache.Store("1", "a", time.Minute))
+	assert.True(t, cache.Store("2", "b", time.Minute))
+	assert.False(t, cache.Store("3", "c", time.Minute))
+
+	assert.True(t, cache.Delete("1"))
+	assert.False(t, cache.Delete("1"))
+	assert.Nil(t, cache.Get("1"))
+	assert.Equal(t, int64(1), cache.cache.capacity)
+
+	assert.True(t, cache.Store("3", "c", time.Minute))
+	assert.Equal(t, 2, cache.DeleteMany("2", "3", "4"))
+	assert.Nil(t, cache.Get("2"))
+	assert.Nil(t, cache.Get("3"))
+	assert.Equal(t, int64(2), cache.cache.capacity)
+}
+
+func TestSetAddReplace(t *testing.T) {
+	cache := New(testShortKey, testKey, time.Hour, 2, 2).(*bucketCache)
+
+	assert.False(t, cache.Replace("1", "a", time.Minute))
+	assert.Nil(t, cache.Get("1"))
+
+	assert.True(t, cache.Add("1", "a", time.Minute))
+	assert.False(t, cache.Add("1", "b", time.Minute))
+	assert.Equal(t, "a", cache.Get("1"))
+
+	assert.True(t, cache.Replace("1", "b", time.Minute))
+	assert.Equal(t, "b", cache.Get("1"))
+
+	assert.True(t, cache.Set("2", "c", time.Minute))
+	assert.Equal(t, int64(0), cache.cache.capacity)
+
+	// full, but existing keys can still be updated
+	assert.True(t, cache.Set("2", "d", time.Hour))
+	assert.Equal(t, "d", cache.Get("2"))
+	assert.False(t, cache.Set("3", "e", time.Minute))
+	assert.Equal(t, int64(0), cache.cache.capacity)
+}
+
+func TestLazyExpiration(t *testing.T) {
+	cache := New(testShortKey, testKey, time.Hour, 2, 1).(*bucketCache)
+
+	assert.True(t, cache.Store("1", "a", time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+
+	assert.Nil(t, cache.Get("1"))
+	assert.False(t, cache.Replace("1", "b", time.Minute))
+
+	// the expired entry is dropped by the next write, giving its slot back
+	assert.True(t, cache.Store("2", "b", time.Minute))
+	assert.Equal(t, int64(1), cache.cache.capacity)
+	assert.Equal(t, 1, cache.cache.caches[0].len())
+
+	assert.True(t, cache.Store("1", "c", time.Minute))
+	assert.Equal(t, "c", cache.Get("1"))
+}
+
+func TestEviction(t *testing.T) {
+	cache := NewEvicting(testShortKey, testKey, time.Hour, 2, 1, EvictLRU).(*bucketCache)
+
+	assert.True(t, cache.Store("1", "a", time.Minute))
+	assert.True(t, cache.Store("2", "b", time.Minute))
+	assert.Equal(t, "a", cache.Get("1"))
+
+	assert.True(t, cache.Store("3", "c", time.Minute))
+	assert.Nil(t, cache.Get("2"))
+	assert.Equal(t, "a", cache.Get("1"))
+	assert.Equal(t, "c", cache.Get("3"))
+	assert.Equal(t, int64(0), cache.cache.capacity)
+
+	fifo := NewEvicting(testShortKey, testKey, time.Hour, 2, 1, EvictFIFO)
+	assert.True(t, fifo.Store("1", "a", time.Minute))
+	assert.True(t, fifo.Store("2", "b", time.Minute))
+	assert.Equal(t, "a", fifo.Get("1"))
+	assert.True(t, fifo.Store("3", "c", time.Minute))
+	assert.Nil(t, fifo.Get("1"))
+	assert.Equal(t, "b", fifo.Get("2"))
+}
+
+func TestDefaultShortKey(t *testing.T) {
+	cache := New(nil, testKey, time.Hour, 10, 3)
+
+	assert.True(t, cache.Store("1", "a", time.Minute))
+	assert.Equal(t, "a", cache.Get("1"))
+	assert.True(t, cache.Delete("1"))
+}
@@ -0,0 +1,106 @@
+// Package gocachetest provides test helpers for code using gocache.
+package gocachetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/philolight/gocache"
+)
+
+// FakeClock is a gocache.Clock that only moves when told to. Tickers fire from Advance.
+type FakeClock struct {
+	lock    sync.Mutex
+	changed *sync.Cond // broadcast when tickers are added or stopped
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock returns a FakeClock reading now.
+func NewFakeClock(now time.Time) *FakeClock {
+	ret := &FakeClock{now: now}
+	ret.changed = sync.NewCond(&ret.lock)
+
+	return ret
+}
+
+func (r *FakeClock) Now() time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.now
+}
+
+// NewTicker returns a ticker firing every d of fake time. Like time.Ticker, it drops ticks
+// its reader is too slow for.
+func (r *FakeClock) NewTicker(d time.Duration) gocache.Ticker {
+	if d <= 0 {
+		panic("gocachetest: non-positive interval for NewTicker")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ret := &fakeTicker{
+		clock:  r,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   r.now.Add(d),
+	}
+	r.tickers = append(r.tickers, ret)
+	r.changed.Broadcast()
+
+	return ret
+}
+
+// Advance moves the clock by d and fires every ticker that came due on the way.
+func (r *FakeClock) Advance(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.now = r.now.Add(d)
+
+	for _, ticker := range r.tickers {
+		for !ticker.next.After(r.now) {
+			select {
+			case ticker.c <- ticker.next:
+			default:
+			}
+			ticker.next = ticker.next.Add(ticker.period)
+		}
+	}
+}
+
+// BlockUntil waits until n tickers are running, so Advance is not called before, e.g., Run started its ticker.
+func (r *FakeClock) BlockUntil(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for len(r.tickers) < n {
+		r.changed.Wait()
+	}
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (r *fakeTicker) C() <-chan time.Time {
+	return r.c
+}
+
+func (r *fakeTicker) Stop() {
+	r.clock.lock.Lock()
+	defer r.clock.lock.Unlock()
+
+	for i, ticker := range r.clock.tickers {
+		if ticker == r {
+			r.clock.tickers = append(r.clock.tickers[:i], r.clock.tickers[i+1:]...)
+			r.clock.changed.Broadcast()
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package gocache
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+// NewHasher returns a maphash based bucket hash with a random seed of its own, so keys
+// crafted to collide in one cache do not collide in another one.
+func NewHasher[K comparable]() func(key K) uint {
+	seed := maphash.MakeSeed()
+
+	return func(key K) uint {
+		return uint(maphash.Comparable(seed, key))
+	}
+}
+
+// stringHasher is NewHasher for the key strings of a Cacher.
+func stringHasher() func(key string) uint {
+	seed := maphash.MakeSeed()
+
+	return func(key string) uint {
+		return uint(maphash.String(seed, key))
+	}
+}
+
+// Distribution describes how evenly items are spread over the buckets.
+type Distribution struct {
+	Min    int     // items of the emptiest bucket
+	Max    int     // items of the fullest bucket
+	Mean   float64 // items per bucket
+	StdDev float64
+	Skew   float64 // Max over Mean, 1 is perfectly even, 0 for an empty cache
+}
+
+// Distribution reports the bucket occupancy of the stats. A Skew well above 1 means the hash
+// puts too many keys in a few buckets, which then take most of the locking.
+func (r CacheStats) Distribution() Distribution {
+	var ret Distribution
+	if len(r.Buckets) == 0 {
+		return ret
+	}
+
+	ret.Min = math.MaxInt
+	total := 0
+	for _, bucket := range r.Buckets {
+		total += bucket.Items
+		ret.Min = min(ret.Min, bucket.Items)
+		ret.Max = max(ret.Max, bucket.Items)
+	}
+
+	ret.Mean = float64(total) / float64(len(r.Buckets))
+
+	var variance float64
+	for _, bucket := range r.Buckets {
+		d := float64(bucket.Items) - ret.Mean
+		variance += d * d
+	}
+	ret.StdDev = math.Sqrt(variance / float64(len(r.Buckets)))
+
+	if ret.Mean > 0 {
+		ret.Skew = float64(ret.Max) / ret.Mean
+	}
+
+	return ret
+}
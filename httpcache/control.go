@@ -0,0 +1,151 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directives are the Cache-Control directives of a message by lower case name, with unquoted values.
+type directives map[string]string
+
+func parseCacheControl(header http.Header) directives {
+	ret := directives{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			ret[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return ret
+}
+
+func (r directives) has(name string) bool {
+	_, ok := r[name]
+	return ok
+}
+
+// seconds returns a delta-seconds directive. Invalid values are not there at all.
+func (r directives) seconds(name string) (time.Duration, bool) {
+	value, ok := r[name]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return time.Duration(n) * time.Second, true
+}
+
+// date is the Date of a response, or when it was received if it has none.
+func date(header http.Header, received time.Time) time.Time {
+	if ret, err := http.ParseTime(header.Get("Date")); err == nil {
+		return ret
+	}
+	return received
+}
+
+// freshnessLifetime is how long a response is fresh by its explicit expiration (RFC 9111, 4.2.1),
+// zero if it has none or must be revalidated every time.
+func freshnessLifetime(header http.Header, received time.Time, shared bool) time.Duration {
+	cc := parseCacheControl(header)
+	if cc.has("no-cache") {
+		return 0
+	}
+
+	if shared {
+		if ret, ok := cc.seconds("s-maxage"); ok {
+			return ret
+		}
+	}
+	if ret, ok := cc.seconds("max-age"); ok {
+		return ret
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+		if err != nil { // invalid dates are in the past
+			return 0
+		}
+		return max(at.Sub(date(header, received)), 0)
+	}
+
+	return 0
+}
+
+// initialAge is the corrected initial age of a response (RFC 9111, 4.2.3).
+func initialAge(header http.Header, sent, received time.Time) time.Duration {
+	apparent := max(received.Sub(date(header, received)), 0)
+
+	var age time.Duration
+	if n, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && n > 0 {
+		age = time.Duration(n) * time.Second
+	}
+
+	return max(apparent, age+received.Sub(sent))
+}
+
+// storable reports whether a response to req may be stored (RFC 9111, 3).
+func storable(req *http.Request, status int, header http.Header, shared bool) bool {
+	if req.Method != http.MethodGet || status < 200 || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return false
+	}
+
+	reqCC, cc := parseCacheControl(req.Header), parseCacheControl(header)
+	if reqCC.has("no-store") || cc.has("no-store") {
+		return false
+	}
+
+	if shared {
+		if cc.has("private") {
+			return false
+		}
+		// RFC 9111, 3.5
+		if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+			return false
+		}
+	}
+
+	for _, name := range varyNames(header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// varyNames are the request headers a response varies by, canonical.
+func varyNames(header http.Header) []string {
+	var ret []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				ret = append(ret, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return ret
+}
+
+// conditional reports whether req carries validators of its own.
+func conditional(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+func unsafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is a stored response. It is immutable once stored, every hit gets a header of its own
+// and a new reader of body.
+type entry struct {
+	status   int
+	header   http.Header
+	body     []byte
+	received time.Time     // when the response was received
+	initial  time.Duration // corrected initial age
+	lifetime time.Duration // freshness lifetime
+}
+
+func newEntry(status int, header http.Header, body []byte, sent, received time.Time, shared bool) *entry {
+	return &entry{
+		status:   status,
+		header:   header,
+		body:     body,
+		received: received,
+		initial:  initialAge(header, sent, received),
+		lifetime: freshnessLifetime(header, received, shared),
+	}
+}
+
+// age is the current age of the response at now (RFC 9111, 4.2.3).
+func (r *entry) age(now time.Time) time.Duration {
+	return r.initial + max(now.Sub(r.received), 0)
+}
+
+func (r *entry) validators() bool {
+	return r.header.Get("ETag") != "" || r.header.Get("Last-Modified") != ""
+}
+
+// revalidated returns the response updated by the header of a 304 Not Modified response to a revalidation
+// (RFC 9111, 4.3.4).
+func (r *entry) revalidated(header http.Header, sent, received time.Time, shared bool) *entry {
+	merged := r.header.Clone()
+	for name, values := range header {
+		if name == "Content-Length" {
+			continue
+		}
+		merged[name] = values
+	}
+
+	return newEntry(r.status, merged, r.body, sent, received, shared)
+}
+
+// matches reports whether the If-None-Match of req matches the ETag of the response, by weak comparison.
+func (r *entry) matches(req *http.Request) bool {
+	etag := strings.TrimPrefix(r.header.Get("ETag"), "W/")
+	if etag == "" {
+		return false
+	}
+
+	for _, line := range req.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(line, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// answer returns the status and header the response answers req with at now,
+// 304 Not Modified if req already has it.
+func (r *entry) answer(req *http.Request, now time.Time) (int, http.Header) {
+	header := r.header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(r.age(now)/time.Second), 10))
+
+	if !r.matches(req) {
+		return r.status, header
+	}
+
+	for name := range header {
+		switch name {
+		case "Cache-Control", "Content-Location", "Date", "Etag", "Expires", "Vary", "Age", "Last-Modified":
+		default:
+			delete(header, name)
+		}
+	}
+
+	return http.StatusNotModified, header
+}
+
+// response returns the response answering req at now.
+func (r *entry) response(req *http.Request, now time.Time) *http.Response {
+	status, header := r.answer(req, now)
+
+	var body []byte
+	if status != http.StatusNotModified {
+		body = r.body
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// write writes the response answering req at now to w.
+func (r *entry) write(w http.ResponseWriter, req *http.Request, now time.Time) {
+	status, header := r.answer(req, now)
+
+	out := w.Header()
+	for name := range out {
+		delete(out, name)
+	}
+	for name, values := range header {
+		out[name] = values
+	}
+
+	w.WriteHeader(status)
+	if status != http.StatusNotModified && req.Method != http.MethodHead {
+		w.Write(r.body)
+	}
+}
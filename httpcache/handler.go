@@ -0,0 +1,147 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/philolight/gocache"
+)
+
+// Handler is an http.Handler middleware answering GET requests from a Cacher while the stored response is fresh,
+// revalidating it with next when it is stale and storing the responses of next. It is a shared cache by default.
+type Handler struct {
+	store
+	next http.Handler
+}
+
+// NewHandler returns a Handler caching the responses of next in cache. cache must use KeyString, see NewCacher.
+func NewHandler(cache gocache.Cacher, next http.Handler, opts ...Option) (*Handler, error) {
+	cfg, err := newConfig(true, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{store: store{cache: cache, cfg: cfg}, next: next}, nil
+}
+
+func (r *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rec := &recorder{ResponseWriter: w}
+		r.next.ServeHTTP(rec, req)
+		if unsafe(req.Method) && rec.status() < 400 {
+			r.invalidate(req)
+		}
+		return
+	}
+
+	stored := r.lookup(req)
+	if stored != nil && r.fresh(req, stored, r.now()) {
+		stored.write(w, req, r.now())
+		return
+	}
+	if stored == nil && parseCacheControl(req.Header).has("only-if-cached") {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+
+	out := revalidating(req, stored)
+	rec := &recorder{ResponseWriter: w, handler: r, req: req, revalidating: out != req, sent: r.now()}
+	r.next.ServeHTTP(rec, out)
+	rec.finish(stored)
+}
+
+// recorder passes the response of next through, keeping a copy of it if it is storable. The 304 Not Modified
+// response to a revalidation is not passed through, the stored response is written instead.
+type recorder struct {
+	http.ResponseWriter
+	handler      *Handler
+	req          *http.Request
+	revalidating bool
+	sent         time.Time
+
+	code        int // 0 until the header is written
+	received    time.Time
+	header      http.Header // as written, nil unless it is stored or revalidated
+	body        bytes.Buffer
+	notModified bool
+}
+
+func (r *recorder) status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+	return r.code
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code != 0 {
+		return
+	}
+	r.code = code
+
+	if r.handler != nil {
+		r.received = r.handler.now()
+
+		if r.revalidating && code == http.StatusNotModified {
+			r.notModified = true
+			r.header = r.Header().Clone()
+			return
+		}
+
+		if storable(r.req, code, r.Header(), r.handler.cfg.shared) && !r.tooLarge(r.Header()) {
+			r.header = r.Header().Clone()
+		}
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if r.notModified {
+		return len(b), nil
+	}
+	if r.header != nil {
+		if int64(r.body.Len()+len(b)) > r.handler.cfg.maxBodySize { // passed through, not stored
+			r.header = nil
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) tooLarge(header http.Header) bool {
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	return err == nil && length > r.handler.cfg.maxBodySize
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// finish stores what next wrote, or writes the revalidated stored response.
+func (r *recorder) finish(stored *entry) {
+	if r.code == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if r.notModified {
+		stored = stored.revalidated(r.header, r.sent, r.received, r.handler.cfg.shared)
+		r.handler.save(r.req, stored)
+		stored.write(r.ResponseWriter, r.req, r.received)
+		return
+	}
+
+	if r.header != nil {
+		r.handler.save(r.req, newEntry(r.code, r.header, bytes.Clone(r.body.Bytes()), r.sent, r.received, r.handler.cfg.shared))
+	}
+}
@@ -0,0 +1,250 @@
+// Package httpcache caches HTTP responses in a gocache.Cacher following RFC 9111, as an http.RoundTripper
+// for clients and as an http.Handler middleware for servers.
+//
+// Responses are keyed by method, URL and the request headers named by their Vary header. Cache-Control
+// max-age, s-maxage, no-cache, no-store, private and public, Expires and Age decide whether and how long
+// they are fresh. Stale responses with an ETag or Last-Modified are kept a while longer, so they can be
+// revalidated with If-None-Match or If-Modified-Since instead of being fetched again.
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/philolight/gocache"
+)
+
+const (
+	// DefaultRetention is how long a stale response with validators is kept for revalidation by default.
+	DefaultRetention = 10 * time.Minute
+	// DefaultMaxBodySize is the largest body stored by default.
+	DefaultMaxBodySize = 1 << 20
+)
+
+// ErrInvalidOption is wrapped by every error of a bad option value.
+var ErrInvalidOption = errors.New("httpcache: invalid option")
+
+// Option configures NewTransport and NewHandler.
+type Option func(r *config) error
+
+type config struct {
+	clock       gocache.Clock
+	retention   time.Duration
+	shared      bool
+	maxBodySize int64
+}
+
+func newConfig(shared bool, opts []Option) (*config, error) {
+	ret := &config{
+		retention:   DefaultRetention,
+		shared:      shared,
+		maxBodySize: DefaultMaxBodySize,
+	}
+
+	for _, opt := range opts {
+		if err := opt(ret); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOption, fmt.Sprintf(format, args...))
+}
+
+// WithClock tells the time, the system clock by default. Give the Cacher the same clock.
+func WithClock(clock gocache.Clock) Option {
+	return func(r *config) error {
+		if clock == nil {
+			return invalid("clock must not be nil")
+		}
+		r.clock = clock
+		return nil
+	}
+}
+
+// WithRetention keeps stale responses with validators for retention to revalidate them, DefaultRetention by default.
+// Zero drops responses once they are stale.
+func WithRetention(retention time.Duration) Option {
+	return func(r *config) error {
+		if retention < 0 {
+			return invalid("retention must not be negative, got %v", retention)
+		}
+		r.retention = retention
+		return nil
+	}
+}
+
+// WithShared makes a shared cache, which does not store private responses nor responses to requests with
+// Authorization unless they allow it. A Transport is private and a Handler is shared by default.
+func WithShared(shared bool) Option {
+	return func(r *config) error {
+		r.shared = shared
+		return nil
+	}
+}
+
+// WithMaxBodySize stores only responses with bodies of at most size bytes, DefaultMaxBodySize by default.
+// Larger responses are passed through as they are, without buffering more than size bytes of them.
+func WithMaxBodySize(size int64) Option {
+	return func(r *config) error {
+		if size < 0 {
+			return invalid("max body size must not be negative, got %d", size)
+		}
+		r.maxBodySize = size
+		return nil
+	}
+}
+
+// KeyString is the keyString of a Cacher for a Transport or Handler, their keys are strings.
+func KeyString(key interface{}) string {
+	return key.(string)
+}
+
+// NewCacher returns a Cacher for a Transport or Handler, opts are passed to gocache.NewWithOptions.
+func NewCacher(opts ...gocache.Option) (gocache.Cacher, error) {
+	return gocache.NewWithOptions(append([]gocache.Option{gocache.WithKeyString(KeyString)}, opts...)...)
+}
+
+// store keeps responses in a Cacher, shared by Transport and Handler.
+type store struct {
+	cache gocache.Cacher
+	cfg   *config
+}
+
+func (r *store) now() time.Time {
+	if r.cfg.clock == nil {
+		return time.Now()
+	}
+	return r.cfg.clock.Now()
+}
+
+// baseKey is the key of the responses to req before the Vary headers are known.
+func baseKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+// varyKey holds the variants of a base key.
+func varyKey(base string) string {
+	return "vary " + base
+}
+
+// generations numbers the variants of base keys, seeded by the clock so caches shared by several processes
+// do not see the same numbers again after a restart.
+var generations atomic.Uint64
+
+func init() {
+	generations.Store(uint64(time.Now().UnixNano()))
+}
+
+// variants are the Vary header names of the responses of a base key. Their keys are of their generation,
+// so once invalidate dropped them the next variants get a new one and the old responses are not found anymore.
+type variants struct {
+	names      []string
+	generation uint64
+}
+
+// variantKey is the key of the response to a request with header, base itself if it does not vary.
+func variantKey(base string, vary *variants, header http.Header) string {
+	if vary == nil || len(vary.names) == 0 {
+		return base
+	}
+
+	var ret strings.Builder
+	ret.WriteString(base)
+	ret.WriteString("\n#")
+	ret.WriteString(strconv.FormatUint(vary.generation, 10))
+	for _, name := range vary.names {
+		ret.WriteString("\n")
+		ret.WriteString(name)
+		ret.WriteString(": ")
+		ret.WriteString(strings.Join(header.Values(name), ", "))
+	}
+
+	return ret.String()
+}
+
+// lookup returns the stored response to req, nil if there is none.
+func (r *store) lookup(req *http.Request) *entry {
+	base := baseKey(req)
+	vary, _ := r.cache.Get(varyKey(base)).(*variants)
+
+	ret, _ := r.cache.Get(variantKey(base, vary, req.Header)).(*entry)
+	return ret
+}
+
+// save stores stored as the response to req, as long as it is fresh or can be revalidated.
+func (r *store) save(req *http.Request, stored *entry) {
+	ttl := stored.lifetime - stored.age(r.now())
+	if stored.validators() {
+		ttl = max(ttl, 0) + r.cfg.retention
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	base := baseKey(req)
+	var vary *variants
+	if names := varyNames(stored.header); len(names) == 0 {
+		r.cache.Delete(varyKey(base))
+	} else {
+		// the other variants stay reachable as long as they vary by the same names
+		vary, _ = r.cache.Get(varyKey(base)).(*variants)
+		if vary == nil || !slices.Equal(vary.names, names) {
+			vary = &variants{names: names, generation: generations.Add(1)}
+		}
+		r.cache.Set(varyKey(base), vary, ttl)
+	}
+
+	r.cache.Set(variantKey(base, vary, req.Header), stored, ttl)
+}
+
+// invalidate drops the stored responses to the URL of req after an unsafe request changed it (RFC 9111, 4.4).
+// Responses varying by request headers are dropped with their variants, their keys are not used again.
+func (r *store) invalidate(req *http.Request) {
+	base := http.MethodGet + " " + req.URL.String()
+	r.cache.DeleteMany(varyKey(base), base)
+}
+
+// fresh reports whether stored can answer req at now without asking the origin.
+func (r *store) fresh(req *http.Request, stored *entry, now time.Time) bool {
+	cc := parseCacheControl(req.Header)
+	if cc.has("no-cache") || (len(cc) == 0 && strings.Contains(req.Header.Get("Pragma"), "no-cache")) {
+		return false
+	}
+
+	age := stored.age(now)
+	if maxAge, ok := cc.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := cc.seconds("min-fresh"); ok && stored.lifetime-age < minFresh {
+		return false
+	}
+
+	return age < stored.lifetime
+}
+
+// revalidating returns req asking the origin whether stored is still valid, or req itself if it can not.
+func revalidating(req *http.Request, stored *entry) *http.Request {
+	if stored == nil || !stored.validators() || conditional(req) {
+		return req
+	}
+
+	ret := req.Clone(req.Context())
+	if etag := stored.header.Get("ETag"); etag != "" {
+		ret.Header.Set("If-None-Match", etag)
+	}
+	if modified := stored.header.Get("Last-Modified"); modified != "" {
+		ret.Header.Set("If-Modified-Since", modified)
+	}
+
+	return ret
+}
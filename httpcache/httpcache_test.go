@@ -0,0 +1,227 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/philolight/gocache"
+	"github.com/philolight/gocache/gocachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCacher(t *testing.T, clock gocache.Clock) gocache.Cacher {
+	cache, err := NewCacher(gocache.WithClock(clock), gocache.WithCapacity(100), gocache.WithBuckets(1))
+	assert.NoError(t, err)
+	return cache
+}
+
+// origin answers with a counter, so tests see whether a response came from the cache.
+func origin(clock gocache.Clock, calls *int, header http.Header) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*calls++
+		w.Header().Set("Date", clock.Now().UTC().Format(http.TimeFormat))
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+
+		if etag := header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fmt.Fprintf(w, "%s %d", req.Header.Get("Accept-Language"), *calls)
+	})
+}
+
+func get(t *testing.T, client *http.Client, url string, header ...string) (int, string, http.Header) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), resp.Header
+}
+
+func TestTransport(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	calls := 0
+	server := httptest.NewServer(origin(clock, &calls, http.Header{
+		"Cache-Control": {"max-age=60"},
+		"Etag":          {`"v1"`},
+		"Vary":          {"Accept-Language"},
+	}))
+	defer server.Close()
+
+	transport, err := NewTransport(newTestCacher(t, clock), nil, WithClock(clock))
+	assert.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	_, body, _ := get(t, client, server.URL, "Accept-Language", "en")
+	assert.Equal(t, "en 1", body)
+
+	clock.Advance(10 * time.Second)
+	_, body, header := get(t, client, server.URL, "Accept-Language", "en")
+	assert.Equal(t, "en 1", body)
+	assert.Equal(t, "10", header.Get("Age"))
+
+	_, body, _ = get(t, client, server.URL, "Accept-Language", "ko") // varies
+	assert.Equal(t, "ko 2", body)
+
+	status, _, _ := get(t, client, server.URL, "Accept-Language", "en", "If-None-Match", `"v1"`)
+	assert.Equal(t, http.StatusNotModified, status)
+	assert.Equal(t, 2, calls)
+
+	// stale, revalidated by the ETag
+	clock.Advance(time.Minute)
+	status, body, header = get(t, client, server.URL, "Accept-Language", "en")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "en 1", body)
+	assert.Equal(t, "0", header.Get("Age"))
+	assert.Equal(t, 3, calls)
+
+	_, body, _ = get(t, client, server.URL, "Accept-Language", "en", "Cache-Control", "no-cache")
+	assert.Equal(t, "en 1", body)
+	assert.Equal(t, 4, calls)
+
+	// unsafe methods invalidate
+	resp, err := client.Post(server.URL, "text/plain", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	_, body, _ = get(t, client, server.URL, "Accept-Language", "en")
+	assert.Equal(t, "en 6", body)
+	_, body, _ = get(t, client, server.URL, "Accept-Language", "ko") // the other variant is gone too
+	assert.Equal(t, "ko 7", body)
+	_, body, _ = get(t, client, server.URL, "Accept-Language", "en")
+	assert.Equal(t, "en 6", body)
+}
+
+func TestTransportNotStored(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	for _, header := range []http.Header{
+		{"Cache-Control": {"no-store, max-age=60"}},
+		{"Vary": {"*"}, "Cache-Control": {"max-age=60"}},
+		{"Expires": {"0"}},
+		{},
+	} {
+		calls := 0
+		server := httptest.NewServer(origin(clock, &calls, header))
+
+		transport, _ := NewTransport(newTestCacher(t, clock), nil, WithClock(clock))
+		client := &http.Client{Transport: transport}
+
+		get(t, client, server.URL)
+		get(t, client, server.URL)
+		assert.Equal(t, 2, calls, "%v", header)
+
+		server.Close()
+	}
+
+	transport, _ := NewTransport(newTestCacher(t, clock), nil, WithClock(clock))
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("Cache-Control", "only-if-cached")
+	resp, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	_, err = NewTransport(newTestCacher(t, clock), nil, WithRetention(-time.Second))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestHandler(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	calls := 0
+	handler, err := NewHandler(newTestCacher(t, clock), origin(clock, &calls, http.Header{
+		"Cache-Control": {"s-maxage=60, max-age=0"},
+		"Etag":          {`"v1"`},
+	}), WithClock(clock))
+	assert.NoError(t, err)
+
+	serve := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/token", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, " 1", serve().Body.String())
+	assert.Equal(t, " 1", serve().Body.String()) // shared, so s-maxage wins
+	assert.Equal(t, 1, calls)
+
+	clock.Advance(2 * time.Minute)
+	rec := serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, " 1", rec.Body.String())
+	assert.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+	assert.Equal(t, 2, calls)
+
+	private := 0
+	handler, _ = NewHandler(newTestCacher(t, clock), origin(clock, &private, http.Header{"Cache-Control": {"private, max-age=60"}}), WithClock(clock))
+	serve()
+	serve()
+	assert.Equal(t, 2, private)
+
+	// a shared cache does not store answers to requests with credentials unless allowed
+	authorized := 0
+	handler, _ = NewHandler(newTestCacher(t, clock), origin(clock, &authorized, http.Header{"Cache-Control": {"max-age=60"}}), WithClock(clock))
+	serve("Authorization", "Bearer x")
+	serve("Authorization", "Bearer x")
+	assert.Equal(t, 2, authorized)
+}
+
+func TestBodies(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, req.URL.Path)
+	}))
+	defer server.Close()
+
+	transport, err := NewTransport(newTestCacher(t, clock), nil, WithClock(clock), WithMaxBodySize(6))
+	assert.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 3; i++ { // every hit reads a body of its own
+		_, body, _ := get(t, client, server.URL+"/small")
+		assert.Equal(t, "/small", body)
+	}
+	assert.Equal(t, 1, calls)
+
+	for i := 0; i < 2; i++ {
+		_, body, _ := get(t, client, server.URL+"/too_large")
+		assert.Equal(t, "/too_large", body)
+	}
+	assert.Equal(t, 3, calls)
+
+	handled := 0
+	handler, _ := NewHandler(newTestCacher(t, clock), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handled++
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, req.URL.Path)
+	}), WithClock(clock), WithMaxBodySize(6))
+
+	for _, path := range []string{"/small", "/small", "/too_large", "/too_large"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, path, rec.Body.String())
+	}
+	assert.Equal(t, 3, handled)
+}
@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/philolight/gocache"
+)
+
+// Transport is an http.RoundTripper answering GET requests from a Cacher while the stored response is fresh,
+// revalidating it when it is stale and storing the responses of next. It is a private cache by default.
+type Transport struct {
+	store
+	next http.RoundTripper
+}
+
+// NewTransport returns a Transport caching the responses of next, http.DefaultTransport if nil, in cache.
+// cache must use KeyString, see NewCacher.
+func NewTransport(cache gocache.Cacher, next http.RoundTripper, opts ...Option) (*Transport, error) {
+	cfg, err := newConfig(false, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Transport{store: store{cache: cache, cfg: cfg}, next: next}, nil
+}
+
+func (r *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		resp, err := r.next.RoundTrip(req)
+		if err == nil && unsafe(req.Method) && resp.StatusCode < 400 {
+			r.invalidate(req)
+		}
+		return resp, err
+	}
+
+	stored := r.lookup(req)
+	if stored != nil && r.fresh(req, stored, r.now()) {
+		return stored.response(req, r.now()), nil
+	}
+	if stored == nil && parseCacheControl(req.Header).has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+
+	out := revalidating(req, stored)
+
+	sent := r.now()
+	resp, err := r.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	received := r.now()
+
+	if out != req && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		stored = stored.revalidated(resp.Header, sent, received, r.cfg.shared)
+		r.save(req, stored)
+
+		return stored.response(req, received), nil
+	}
+
+	if !storable(req, resp.StatusCode, resp.Header, r.cfg.shared) || resp.ContentLength > r.cfg.maxBodySize {
+		return resp, nil
+	}
+
+	// one byte more than allowed tells a body is too large without reading all of it
+	body, err := io.ReadAll(io.LimitReader(resp.Body, r.cfg.maxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > r.cfg.maxBodySize {
+		resp.Body = passThrough{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	r.save(req, newEntry(resp.StatusCode, resp.Header.Clone(), body, sent, received, r.cfg.shared))
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// passThrough is the body of a response too large to store, the part read already followed by the rest.
+type passThrough struct {
+	io.Reader
+	io.Closer
+}
+
+// gatewayTimeout answers an only-if-cached request nothing is stored for (RFC 9111, 5.2.1.7).
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 " + http.StatusText(http.StatusGatewayTimeout),
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
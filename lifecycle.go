@@ -0,0 +1,121 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by Run once the cache is closed.
+	ErrClosed = errors.New("gocache: closed")
+	// ErrRunning is returned by Run while another Run of the same cache has not returned.
+	ErrRunning = errors.New("gocache: already running")
+)
+
+type lifecycle struct {
+	lock      sync.Mutex
+	closed    chan struct{} // closed by the first Close
+	closeOnce sync.Once
+	running   chan struct{} // non nil while Run runs, closed when it returns
+}
+
+// Run refreshes the cache every refreshDuration until ctx is done or the cache is closed.
+// It returns ctx.Err() if ctx stopped it and nil if Close did. Only one Run can run at a time.
+func (r *Cache[K, V]) Run(ctx context.Context) error {
+	r.life.lock.Lock()
+	select {
+	case <-r.life.closed:
+		r.life.lock.Unlock()
+		return ErrClosed
+	default:
+	}
+
+	if r.life.running != nil {
+		r.life.lock.Unlock()
+		return ErrRunning
+	}
+
+	done := make(chan struct{})
+	r.life.running = done
+	batchDelay := r.batchDelay
+	r.life.lock.Unlock()
+
+	defer func() {
+		r.life.lock.Lock()
+		r.life.running = nil
+		r.life.lock.Unlock()
+
+		close(done)
+	}()
+
+	ticker := r.clock.NewTicker(r.refreshDuration)
+	defer ticker.Stop()
+
+	var batches <-chan time.Time // nil, so never ready, unless batches have a delay
+	if batchDelay > 0 {
+		batchTicker := r.clock.NewTicker(batchDelay)
+		defer batchTicker.Stop()
+		batches = batchTicker.C()
+	}
+
+	var writes <-chan time.Time // nil unless writes are behind
+	if r.behind != nil {
+		writeTicker := r.clock.NewTicker(r.behind.delay)
+		defer writeTicker.Stop()
+		writes = writeTicker.C()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.life.closed:
+			return nil
+		case <-ticker.C():
+			r.refresh()
+		case now := <-batches:
+			r.flushOlder(now)
+		case now := <-writes:
+			r.flushWrites(ctx, now, false)
+		}
+	}
+}
+
+func (r *Cache[K, V]) closed() bool {
+	select {
+	case <-r.life.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops Run and waits for it to return, so no goroutine of the cache is left when it returns.
+// Background reloads are canceled and waited for too. Pending batches are published and changes waiting
+// for write-behind are written, failed ones are not retried anymore. It can be called
+// any number of times, before or after Run. The cache still serves Get and Store after Close,
+// expired items are just not swept, stale items not reloaded and writes written through instead of behind anymore.
+func (r *Cache[K, V]) Close() error {
+	r.life.lock.Lock()
+	r.life.closeOnce.Do(func() {
+		close(r.life.closed)
+	})
+	done := r.life.running
+	r.life.lock.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	r.reloads.cancel()
+	r.reloads.wg.Wait()
+
+	r.Flush()
+	if r.behind != nil {
+		r.flushWrites(context.Background(), r.clock.Now(), true) // bounded by WithWriteTimeout
+	}
+
+	return nil
+}
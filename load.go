@@ -0,0 +1,121 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLoaderPanicked is returned to callers sharing a load whose loader panicked.
+var ErrLoaderPanicked = errors.New("gocache: loader panicked")
+
+// Loader loads the value of key and how long it is fresh, see WithLoader.
+type Loader[K comparable, V any] interface {
+	Load(ctx context.Context, key K) (V, time.Duration, error)
+}
+
+// LoaderFunc is a func as a Loader.
+type LoaderFunc[K comparable, V any] func(ctx context.Context, key K) (V, time.Duration, error)
+
+func (r LoaderFunc[K, V]) Load(ctx context.Context, key K) (V, time.Duration, error) {
+	return r(ctx, key)
+}
+
+// GetOrLoad returns the live value of key, or calls loader on a miss and stores what it returns
+// for the duration it returns. Concurrent misses of the same key share a single loader call,
+// which runs with ctx of the caller that started it. A failed load or a non-positive duration
+// stores nothing, except ErrNotFound, which caches the miss by StoreMiss. A cached miss returns ErrNotFound.
+func (r *Cache[K, V]) GetOrLoad(ctx context.Context, key K, loader func(ctx context.Context) (V, time.Duration, error)) (V, error) {
+	switch stored, presence := r.Lookup(key); presence {
+	case Present:
+		return stored, nil
+	case Absent:
+		return stored, ErrNotFound
+	}
+
+	return r.load(ctx, key, loader)
+}
+
+// load calls loader for a miss of key, once for concurrent misses. A change of key waiting for write-behind
+// is newer than what loader could load, so it is returned instead.
+func (r *Cache[K, V]) load(ctx context.Context, key K, loader func(ctx context.Context) (V, time.Duration, error)) (V, error) {
+	return r.loads.do(ctx, key, func() (V, error) {
+		if stored := r.caches[r.getBucketIndex(key)].get(key); stored != nil { // a load finished between the miss and this one
+			if stored.miss {
+				return stored.v, ErrNotFound
+			}
+			return stored.v, nil
+		}
+
+		if change, ok := r.pending(key); ok {
+			if change.Deleted {
+				return change.Value, ErrNotFound
+			}
+			return change.Value, nil
+		}
+
+		v, duration, err := loader(ctx)
+		if errors.Is(err, ErrNotFound) && duration > 0 {
+			r.StoreMiss(key, duration)
+		}
+		if err != nil {
+			return v, err
+		}
+
+		if duration > 0 {
+			r.write(key, v, computedCost, duration, modeSet, false) // not written back, it comes from there
+		}
+
+		return v, nil
+	})
+}
+
+type flight[V any] struct {
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// flightGroup deduplicates concurrent calls by key. The zero value is ready to use.
+type flightGroup[K comparable, V any] struct {
+	lock    sync.Mutex
+	flights map[K]*flight[V]
+}
+
+// do calls fn unless a call of key is in flight already, in which case it waits for that call's result
+// or for ctx to be done.
+func (r *flightGroup[K, V]) do(ctx context.Context, key K, fn func() (V, error)) (V, error) {
+	r.lock.Lock()
+	if r.flights == nil {
+		r.flights = make(map[K]*flight[V])
+	}
+
+	if f, ok := r.flights[key]; ok {
+		r.lock.Unlock()
+
+		select {
+		case <-f.done:
+			return f.v, f.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+
+	f := &flight[V]{done: make(chan struct{}), err: ErrLoaderPanicked}
+	r.flights[key] = f
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		delete(r.flights, key)
+		r.lock.Unlock()
+
+		close(f.done)
+	}()
+
+	f.v, f.err = fn()
+
+	return f.v, f.err
+}
@@ -0,0 +1,233 @@
+// Package metrics exports gocache stats in the Prometheus text exposition format
+// without depending on the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/philolight/gocache"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Source is anything reporting gocache stats, such as a gocache.Cacher or a *gocache.Cache.
+type Source = gocache.StatsSource
+
+// Collector exports the stats of named caches, each one labeled cache="name".
+// It is a gocache.MetricsRegistry, so caches can register themselves with gocache.WithMetrics.
+type Collector struct {
+	lock    sync.RWMutex
+	sources map[string]Source
+}
+
+// NewCollector returns a Collector exporting source under name.
+func NewCollector(name string, source Source) *Collector {
+	ret := &Collector{
+		sources: make(map[string]Source),
+	}
+	ret.Register(name, source)
+
+	return ret
+}
+
+// Register exports source under name as well, replacing whatever was registered under it.
+func (r *Collector) Register(name string, source Source) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.sources[name] = source
+}
+
+// Unregister stops exporting the cache registered under name.
+func (r *Collector) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.sources, name)
+}
+
+func (r *Collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+type sample struct {
+	cache string
+	stats gocache.CacheStats
+}
+
+// WriteTo writes the stats of every registered cache in the text exposition format.
+func (r *Collector) WriteTo(w io.Writer) (int64, error) {
+	r.lock.RLock()
+	samples := make([]sample, 0, len(r.sources))
+	for name, source := range r.sources {
+		samples = append(samples, sample{cache: name, stats: source.Stats()})
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].cache < samples[j].cache
+	})
+
+	out := &writer{w: bufio.NewWriter(w)}
+
+	out.family("gocache_hits_total", "counter", "Lookups that found a live item.")
+	for _, s := range samples {
+		out.integer(s.stats.Hits, "gocache_hits_total", "cache", s.cache)
+	}
+
+	out.family("gocache_misses_total", "counter", "Lookups that found nothing or an expired item.")
+	for _, s := range samples {
+		out.integer(s.stats.Misses, "gocache_misses_total", "cache", s.cache)
+	}
+
+	out.family("gocache_hit_ratio", "gauge", "Hits over lookups since the cache was created.")
+	for _, s := range samples {
+		out.float(s.stats.HitRatio(), "gocache_hit_ratio", "cache", s.cache)
+	}
+
+	out.family("gocache_items", "gauge", "Cached items, including expired ones not removed yet.")
+	for _, s := range samples {
+		out.float(float64(s.stats.Items), "gocache_items", "cache", s.cache)
+	}
+
+	out.family("gocache_size", "gauge", "Limit number of cached items.")
+	for _, s := range samples {
+		out.float(float64(s.stats.Size), "gocache_size", "cache", s.cache)
+	}
+
+	out.family("gocache_capacity_remaining", "gauge", "Slots or cost left for new keys.")
+	for _, s := range samples {
+		out.float(float64(s.stats.Capacity), "gocache_capacity_remaining", "cache", s.cache)
+	}
+
+	out.family("gocache_bucket_skew", "gauge", "Items of the fullest bucket over the mean items per bucket.")
+	for _, s := range samples {
+		out.float(s.stats.Distribution().Skew, "gocache_bucket_skew", "cache", s.cache)
+	}
+
+	out.family("gocache_stores_total", "counter", "Writes that stored a value.")
+	for _, s := range samples {
+		out.integer(s.stats.Stores, "gocache_stores_total", "cache", s.cache)
+	}
+
+	out.family("gocache_rejected_stores_total", "counter", "Writes that stored nothing, by reason.")
+	for _, s := range samples {
+		out.integer(s.stats.RejectedFull, "gocache_rejected_stores_total", "cache", s.cache, "reason", "full")
+		out.integer(s.stats.RejectedExists, "gocache_rejected_stores_total", "cache", s.cache, "reason", "exists")
+		out.integer(s.stats.RejectedAbsent, "gocache_rejected_stores_total", "cache", s.cache, "reason", "absent")
+	}
+
+	out.family("gocache_removed_total", "counter", "Items removed from the cache, by reason.")
+	for _, s := range samples {
+		out.integer(s.stats.Evictions, "gocache_removed_total", "cache", s.cache, "reason", "evicted")
+		out.integer(s.stats.Expirations, "gocache_removed_total", "cache", s.cache, "reason", "expired")
+		out.integer(s.stats.Deletes, "gocache_removed_total", "cache", s.cache, "reason", "deleted")
+	}
+
+	out.family("gocache_swaps_total", "counter", "Front and back map swaps.")
+	for _, s := range samples {
+		out.integer(s.stats.Swaps, "gocache_swaps_total", "cache", s.cache)
+	}
+
+	out.family("gocache_refreshes_total", "counter", "Expiration sweeps of all buckets.")
+	for _, s := range samples {
+		out.integer(s.stats.Refreshes, "gocache_refreshes_total", "cache", s.cache)
+	}
+
+	out.family("gocache_refresh_duration_seconds", "gauge", "Duration of the last expiration sweep of all buckets.")
+	for _, s := range samples {
+		out.float(s.stats.RefreshDuration.Seconds(), "gocache_refresh_duration_seconds", "cache", s.cache)
+	}
+
+	out.family("gocache_lock_wait_seconds", "histogram", "Time writers waited for a bucket lock.")
+	for _, s := range samples {
+		out.histogram(s.stats.BackLockWait, "gocache_lock_wait_seconds", "cache", s.cache, "lock", "back")
+	}
+
+	out.family("gocache_swap_wait_seconds", "histogram", "Time swaps waited for the readers of the old front to leave.")
+	for _, s := range samples {
+		out.histogram(s.stats.SwapWait, "gocache_swap_wait_seconds", "cache", s.cache)
+	}
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+
+	return out.n, out.err
+}
+
+// writer writes exposition lines and keeps the first error.
+type writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (r *writer) printf(format string, args ...interface{}) {
+	if r.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(r.w, format, args...)
+	r.n += int64(n)
+	r.err = err
+}
+
+func (r *writer) family(name string, kind string, help string) {
+	r.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (r *writer) integer(v uint64, name string, labels ...string) {
+	r.printf("%s%s %d\n", name, labelString(labels), v)
+}
+
+func (r *writer) float(v float64, name string, labels ...string) {
+	r.printf("%s%s %s\n", name, labelString(labels), strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (r *writer) histogram(h gocache.Histogram, name string, labels ...string) {
+	var cumulative uint64
+	for i, bound := range gocache.LockWaitBounds {
+		cumulative += h.Counts[i]
+		le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+		r.integer(cumulative, name+"_bucket", append(labels, "le", le)...)
+	}
+
+	cumulative += h.Counts[len(gocache.LockWaitBounds)]
+	r.integer(cumulative, name+"_bucket", append(labels, "le", "+Inf")...)
+	r.float(h.Sum.Seconds(), name+"_sum", labels...)
+	r.integer(cumulative, name+"_count", labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats name, value pairs as {name="value",...}.
+func labelString(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	ret := make([]byte, 0, 64)
+	ret = append(ret, '{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			ret = append(ret, ',')
+		}
+		ret = append(ret, labels[i]...)
+		ret = append(ret, '=', '"')
+		ret = append(ret, labelEscaper.Replace(labels[i+1])...)
+		ret = append(ret, '"')
+	}
+	ret = append(ret, '}')
+
+	return string(ret)
+}
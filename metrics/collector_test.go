@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/philolight/gocache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	cache := gocache.NewCache[int, string](func(key int) uint { return uint(key) }, time.Hour, 10, 2, nil)
+	cache.Store(1, "a", time.Minute)
+	cache.Get(1)
+	cache.Get(2)
+
+	collector := NewCollector(`auth "tokens"`, cache)
+
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE gocache_hits_total counter\n")
+	assert.Contains(t, body, `gocache_hits_total{cache="auth \"tokens\""} 1`+"\n")
+	assert.Contains(t, body, `gocache_hit_ratio{cache="auth \"tokens\""} 0.5`+"\n")
+	assert.Contains(t, body, `gocache_capacity_remaining{cache="auth \"tokens\""} 9`+"\n")
+	assert.Contains(t, body, `gocache_swap_wait_seconds_count{cache="auth \"tokens\""} 1`+"\n")
+	assert.Contains(t, body, `gocache_lock_wait_seconds_bucket{cache="auth \"tokens\"",lock="back",le="+Inf"} 1`+"\n")
+}
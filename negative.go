@@ -0,0 +1,40 @@
+package gocache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by GetOrLoad for a miss cached by StoreMiss. A loader returns it for a key
+// that does not exist, which caches the miss for the duration the loader returns.
+var ErrNotFound = errors.New("gocache: not found")
+
+// Presence tells what Lookup knows about a key.
+type Presence int
+
+const (
+	Unknown Presence = iota // nothing is cached for the key
+	Present                 // a value is cached for the key
+	Absent                  // the key is known not to exist, see StoreMiss
+)
+
+func (r Presence) String() string {
+	switch r {
+	case Unknown:
+		return "unknown"
+	case Present:
+		return "present"
+	case Absent:
+		return "absent"
+	default:
+		return "invalid"
+	}
+}
+
+// StoreMiss caches for ttl that key does not exist, or is denied, replacing a value cached for it.
+// Misses take capacity of their own, see WithMissCapacity, so they never evict values or the other way round.
+// They are never served stale, nor reloaded, nor passed to OnEvict.
+func (r *Cache[K, V]) StoreMiss(key K, ttl time.Duration) bool {
+	var zero V
+	return r.write(key, zero, 1, ttl, modeSet, true)
+}
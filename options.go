@@ -0,0 +1,574 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultCapacity        = 10000
+	DefaultBuckets         = 100
+	DefaultRefreshInterval = time.Second
+	DefaultWriteTimeout    = 30 * time.Second
+)
+
+// ErrInvalidOption is wrapped by every error of a bad option value.
+var ErrInvalidOption = errors.New("gocache: invalid option")
+
+// Option configures NewWithOptions and NewCacheWithOptions.
+type Option func(r *config) error
+
+type config struct {
+	capacity        int
+	maxCost         int64
+	missCapacity    int
+	cost            interface{} // func(value V) int64 of the cache value type
+	buckets         int
+	refreshInterval time.Duration
+	hasher          interface{} // func(key K) uint of the cache key type
+	keyCodec        interface{} // Codec[K] of the cache key type
+	valueCodec      interface{} // Codec[V] of the cache value type
+	keyString       func(key interface{}) string
+	clock           Clock
+	policy          EvictionPolicy
+	batchSize       int
+	batchDelay      time.Duration
+	loader          interface{} // Loader[K, V] of the cache types
+	readThrough     bool
+	writer          interface{} // Writer[K, V] of the cache types
+	writeBatch      int         // changes written behind at once, 0 for write-through
+	writeDelay      time.Duration
+	writeRetries    int
+	writeTimeout    time.Duration
+	staleWindow     time.Duration
+	grace           time.Duration
+	ahead           float64
+	minRate         float64
+	beta            float64
+	metricsName     string
+	metrics         MetricsRegistry
+}
+
+func newConfig(opts []Option) (*config, error) {
+	ret := &config{
+		capacity:        DefaultCapacity,
+		buckets:         DefaultBuckets,
+		refreshInterval: DefaultRefreshInterval,
+		writeTimeout:    DefaultWriteTimeout,
+		clock:           systemClock{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(ret); err != nil {
+			return nil, err
+		}
+	}
+
+	if ret.readThrough && ret.loader == nil {
+		return nil, invalid("read-through needs a loader, see WithLoader")
+	}
+	if (ret.staleWindow > 0 || ret.ahead > 0) && ret.loader == nil {
+		return nil, invalid("stale-while-revalidate and refresh-ahead need a loader, see WithLoader")
+	}
+	if ret.grace > 0 && ret.staleWindow == 0 {
+		return nil, invalid("stale-if-error needs stale-while-revalidate, see WithStaleWhileRevalidate")
+	}
+	if ret.grace > 0 && ret.grace <= ret.staleWindow {
+		return nil, invalid("stale-if-error grace %v has to be longer than the stale window %v", ret.grace, ret.staleWindow)
+	}
+	if ret.beta > 0 && ret.ahead == 0 {
+		return nil, invalid("xfetch needs refresh-ahead, see WithRefreshAhead")
+	}
+	if ret.writeBatch > 0 && ret.writer == nil {
+		return nil, invalid("write-behind needs a writer, see WithWriter")
+	}
+
+	return ret, nil
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOption, fmt.Sprintf(format, args...))
+}
+
+// WithCapacity limits the number of cached items, DefaultCapacity by default.
+func WithCapacity(size int) Option {
+	return func(r *config) error {
+		if size <= 0 {
+			return invalid("capacity must be positive, got %d", size)
+		}
+		r.capacity = size
+		return nil
+	}
+}
+
+// WithMaxCost bounds the cache by the total cost of its items instead of their number, e.g. by bytes
+// with WithCost. Writes of a full cache evict by the policy of WithEvictionPolicy until the new item fits,
+// from the bucket of their key first and from the other buckets if it can not free enough, or are rejected.
+// WithCapacity is ignored then.
+func WithMaxCost(maxCost int64) Option {
+	return func(r *config) error {
+		if maxCost <= 0 {
+			return invalid("max cost must be positive, got %d", maxCost)
+		}
+		r.maxCost = maxCost
+		return nil
+	}
+}
+
+// WithMissCapacity limits the number of misses cached by StoreMiss, which do not take capacity of values.
+// It is a tenth of WithCapacity by default.
+func WithMissCapacity(size int) Option {
+	return func(r *config) error {
+		if size <= 0 {
+			return invalid("miss capacity must be positive, got %d", size)
+		}
+		r.missCapacity = size
+		return nil
+	}
+}
+
+// WithCost computes the cost of values written without one, 1 per item by default.
+// V is interface{} for NewWithOptions.
+func WithCost[V any](cost func(value V) int64) Option {
+	return func(r *config) error {
+		r.cost = cost
+		return nil
+	}
+}
+
+// WithBuckets sets the number of separately locked buckets, DefaultBuckets by default.
+func WithBuckets(buckets int) Option {
+	return func(r *config) error {
+		if buckets <= 0 {
+			return invalid("buckets must be positive, got %d", buckets)
+		}
+		r.buckets = buckets
+		return nil
+	}
+}
+
+// WithRefreshInterval sets how often Run sweeps expired items, DefaultRefreshInterval by default.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(r *config) error {
+		if interval <= 0 {
+			return invalid("refresh interval must be positive, got %v", interval)
+		}
+		r.refreshInterval = interval
+		return nil
+	}
+}
+
+// WithHasher picks the bucket of a key, a seeded maphash by default. K must be the key type of the cache,
+// interface{} for NewWithOptions.
+func WithHasher[K comparable](hash func(key K) uint) Option {
+	return func(r *config) error {
+		r.hasher = hash
+		return nil
+	}
+}
+
+// WithKeyCodec encodes keys in snapshots, GobCodec by default. A Cacher ignores it, its keys are strings.
+func WithKeyCodec[K any](codec Codec[K]) Option {
+	return func(r *config) error {
+		r.keyCodec = codec
+		return nil
+	}
+}
+
+// WithValueCodec encodes values in snapshots, GobCodec by default. V is interface{} for NewWithOptions.
+func WithValueCodec[V any](codec Codec[V]) Option {
+	return func(r *config) error {
+		r.valueCodec = codec
+		return nil
+	}
+}
+
+// WithKeyString turns the keys of a Cacher into cache keys. NewWithOptions requires it.
+func WithKeyString(keyString func(key interface{}) string) Option {
+	return func(r *config) error {
+		r.keyString = keyString
+		return nil
+	}
+}
+
+// WithClock tells the cache the time, the system clock by default.
+func WithClock(clock Clock) Option {
+	return func(r *config) error {
+		if clock == nil {
+			return invalid("clock must not be nil")
+		}
+		r.clock = clock
+		return nil
+	}
+}
+
+// WithEvictionPolicy evicts by policy when the cache is full instead of rejecting new keys.
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(r *config) error {
+		r.policy = policy
+		return nil
+	}
+}
+
+// WithBatching publishes writes in batches, see Cache.SetBatching.
+func WithBatching(size int, delay time.Duration) Option {
+	return func(r *config) error {
+		if size < 0 || delay < 0 {
+			return invalid("batch size and delay must not be negative, got %d and %v", size, delay)
+		}
+		r.batchSize = size
+		r.batchDelay = delay
+		return nil
+	}
+}
+
+// WithLoader reloads stale items in the background, see WithStaleWhileRevalidate. K and V must be the types
+// of the cache, a Cacher takes a Loader[string, interface{}] of the keyString of its keys.
+func WithLoader[K comparable, V any](loader Loader[K, V]) Option {
+	return func(r *config) error {
+		if loader == nil {
+			return invalid("loader must not be nil")
+		}
+		r.loader = loader
+		return nil
+	}
+}
+
+// WithReadThrough has Get load misses by the loader of WithLoader, see Load.
+func WithReadThrough() Option {
+	return func(r *config) error {
+		r.readThrough = true
+		return nil
+	}
+}
+
+// WithWriter writes what is stored to the cache through to writer, and deletes of the cache too.
+// A write is written to the cache first and removed from it again if writer fails. Items loaded by
+// the cache, expired or evicted are not written. K and V must be the types of the cache,
+// a Cacher takes a Writer[string, interface{}] of the keyString of its keys.
+func WithWriter[K comparable, V any](writer Writer[K, V]) Option {
+	return func(r *config) error {
+		if writer == nil {
+			return invalid("writer must not be nil")
+		}
+		r.writer = writer
+		return nil
+	}
+}
+
+// WithStore reads through and writes through to store, it is WithLoader, WithReadThrough and WithWriter at once.
+func WithStore[K comparable, V any](store Store[K, V]) Option {
+	return func(r *config) error {
+		if store == nil {
+			return invalid("store must not be nil")
+		}
+		r.loader, r.readThrough, r.writer = store, true, store
+		return nil
+	}
+}
+
+// WithWriteBehind makes the writer of WithWriter write behind: Run writes up to size changes at once
+// every delay, only the last change of a key is written. A failed write is retried after delay,
+// doubled by every retry up to a minute, and dropped after retries retries. Close writes what is left.
+func WithWriteBehind(size int, delay time.Duration, retries int) Option {
+	return func(r *config) error {
+		if size <= 0 || delay <= 0 || retries < 0 {
+			return invalid("write-behind needs a positive size and delay and non-negative retries, got %d, %v and %d", size, delay, retries)
+		}
+		r.writeBatch, r.writeDelay, r.writeRetries = size, delay, retries
+		return nil
+	}
+}
+
+// WithWriteTimeout cancels the context of every Write of the writer of WithWriter after timeout,
+// DefaultWriteTimeout by default, so a store that hangs does not hang writes or Close. 0 for no limit.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(r *config) error {
+		if timeout < 0 {
+			return invalid("write timeout must not be negative, got %v", timeout)
+		}
+		r.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WithStaleWhileRevalidate keeps items for window after their TTL. Get serves such a stale item
+// and has it reloaded by the loader of WithLoader in the background, one reload per key at a time.
+func WithStaleWhileRevalidate(window time.Duration) Option {
+	return func(r *config) error {
+		if window < 0 {
+			return invalid("stale window must not be negative, got %v", window)
+		}
+		r.staleWindow = window
+		return nil
+	}
+}
+
+// WithStaleIfError keeps serving a stale item whose reload failed until grace after its TTL,
+// past the window of WithStaleWhileRevalidate, which it needs. Both are measured from the TTL,
+// so grace has to be longer than the window. Reloads are retried by Get meanwhile,
+// a second after the last one failed at the earliest.
+func WithStaleIfError(grace time.Duration) Option {
+	return func(r *config) error {
+		if grace < 0 {
+			return invalid("stale grace must not be negative, got %v", grace)
+		}
+		r.grace = grace
+		return nil
+	}
+}
+
+// WithRefreshAhead reloads hot items in the background before their TTL, by the loader of WithLoader.
+// A Get of an item in the last fraction of its TTL reloads it if the item was read at least minRate times
+// per second since it was first stored, e.g. 0.2 and 100 reload items read 100 times a second once 80% of their TTL passed.
+func WithRefreshAhead(fraction float64, minRate float64) Option {
+	return func(r *config) error {
+		if fraction <= 0 || fraction > 1 || minRate < 0 {
+			return invalid("refresh ahead needs a fraction in (0, 1] and a non-negative rate, got %v and %v", fraction, minRate)
+		}
+		r.ahead = fraction
+		r.minRate = minRate
+		return nil
+	}
+}
+
+// WithXFetch makes WithRefreshAhead reload hot items at a random point of their last fraction of TTL
+// by XFetch probabilistic early expiration, so many instances caching the same key do not reload it at the same moment.
+// The larger beta, the earlier, 1 is the usual choice.
+func WithXFetch(beta float64) Option {
+	return func(r *config) error {
+		if beta <= 0 {
+			return invalid("xfetch beta must be positive, got %v", beta)
+		}
+		r.beta = beta
+		return nil
+	}
+}
+
+// StatsSource is anything reporting cache stats.
+type StatsSource interface {
+	Stats() CacheStats
+}
+
+// MetricsRegistry exports the stats of named caches, such as metrics.Collector.
+type MetricsRegistry interface {
+	Register(name string, source StatsSource)
+}
+
+// WithMetrics registers the cache to registry under name.
+func WithMetrics(name string, registry MetricsRegistry) Option {
+	return func(r *config) error {
+		if registry == nil {
+			return invalid("metrics registry must not be nil")
+		}
+		r.metricsName = name
+		r.metrics = registry
+		return nil
+	}
+}
+
+// NewCacheWithOptions returns a Cache configured by opts, or an error wrapping ErrInvalidOption.
+func NewCacheWithOptions[K comparable, V any](opts ...Option) (*Cache[K, V], error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var hash func(key K) uint
+	if cfg.hasher != nil {
+		var ok bool
+		if hash, ok = cfg.hasher.(func(key K) uint); !ok {
+			return nil, invalid("hasher is %T, the cache needs func(%T) uint", cfg.hasher, *new(K))
+		}
+	}
+
+	ret := newCache[K, V](cfg, hash)
+
+	if cfg.keyCodec != nil {
+		var ok bool
+		if ret.keyCodec, ok = cfg.keyCodec.(Codec[K]); !ok {
+			return nil, invalid("key codec is %T, the cache needs a Codec[%T]", cfg.keyCodec, *new(K))
+		}
+	}
+
+	if err := setValueOptions(ret, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.loader != nil {
+		var ok bool
+		if ret.loader, ok = cfg.loader.(Loader[K, V]); !ok {
+			return nil, invalid("loader is %T, the cache needs a Loader[%T, %T]", cfg.loader, *new(K), *new(V))
+		}
+	}
+
+	if cfg.writer != nil {
+		var ok bool
+		if ret.writer, ok = cfg.writer.(Writer[K, V]); !ok {
+			return nil, invalid("writer is %T, the cache needs a Writer[%T, %T]", cfg.writer, *new(K), *new(V))
+		}
+	}
+
+	// last, a cache rejected by the checks above must not stay registered
+	if cfg.metrics != nil {
+		cfg.metrics.Register(cfg.metricsName, ret)
+	}
+
+	return ret, nil
+}
+
+// setValueOptions applies the options typed by the value type of the cache.
+func setValueOptions[K comparable, V any](cache *Cache[K, V], cfg *config) error {
+	if cfg.valueCodec != nil {
+		var ok bool
+		if cache.valueCodec, ok = cfg.valueCodec.(Codec[V]); !ok {
+			return invalid("value codec is %T, the cache needs a Codec[%T]", cfg.valueCodec, *new(V))
+		}
+	}
+
+	if cfg.cost != nil {
+		var ok bool
+		if cache.cost, ok = cfg.cost.(func(value V) int64); !ok {
+			return invalid("cost is %T, the cache needs func(%T) int64", cfg.cost, *new(V))
+		}
+	}
+
+	return nil
+}
+
+// NewWithOptions returns a Cacher configured by opts, or an error wrapping ErrInvalidOption.
+// WithKeyString is required.
+func NewWithOptions(opts ...Option) (Cacher, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.keyString == nil {
+		return nil, invalid("WithKeyString is required")
+	}
+
+	var shortKeyString func(key interface{}) uint
+	if cfg.hasher != nil {
+		var ok bool
+		if shortKeyString, ok = cfg.hasher.(func(key interface{}) uint); !ok {
+			return nil, invalid("hasher is %T, a Cacher needs func(interface {}) uint", cfg.hasher)
+		}
+	}
+
+	ret := &bucketCache{
+		cache:          newCache[cacherKey, interface{}](cfg, cacherKey.bucketHash),
+		shortKeyString: shortKeyString,
+		keyString:      cfg.keyString,
+	}
+
+	if shortKeyString == nil {
+		ret.hashKeyString = stringHasher()
+	}
+
+	ret.cache.keyCodec = cacherKeyCodec{hash: ret.hashKeyString}
+	if err := setValueOptions(ret.cache, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.loader != nil {
+		loader, ok := cfg.loader.(Loader[string, interface{}])
+		if !ok {
+			return nil, invalid("loader is %T, a Cacher needs a Loader[string, interface {}]", cfg.loader)
+		}
+
+		ret.cache.loader = LoaderFunc[cacherKey, interface{}](func(ctx context.Context, key cacherKey) (interface{}, time.Duration, error) {
+			return loader.Load(ctx, key.key)
+		})
+	}
+
+	if cfg.writer != nil {
+		writer, ok := cfg.writer.(Writer[string, interface{}])
+		if !ok {
+			return nil, invalid("writer is %T, a Cacher needs a Writer[string, interface {}]", cfg.writer)
+		}
+
+		ret.cache.writer = WriterFunc[cacherKey, interface{}](func(ctx context.Context, changes []Change[cacherKey, interface{}]) error {
+			converted := make([]Change[string, interface{}], len(changes))
+			for i, change := range changes {
+				converted[i] = Change[string, interface{}]{Key: change.Key.key, Value: change.Value, Deleted: change.Deleted}
+			}
+			return writer.Write(ctx, converted)
+		})
+	}
+
+	if cfg.metrics != nil {
+		cfg.metrics.Register(cfg.metricsName, ret.cache)
+	}
+
+	return ret, nil
+}
+
+func newCache[K comparable, V any](cfg *config, hash func(key K) uint) *Cache[K, V] {
+	if hash == nil {
+		hash = NewHasher[K]()
+	}
+
+	size, bucketSize := int64(cfg.capacity), cfg.buckets
+	keys := cfg.capacity // known number of items to size the maps for
+	if cfg.maxCost > 0 {
+		size, keys = cfg.maxCost, 0
+	}
+	missSize := int64(cfg.missCapacity)
+	if missSize == 0 {
+		missSize = max(int64(cfg.capacity)/10, 1)
+	}
+
+	ret := &Cache[K, V]{
+		caches:          make([]BaseCache[K, V], bucketSize, bucketSize),
+		size:            size,
+		bucketSize:      bucketSize,
+		capacity:        size,
+		missSize:        missSize,
+		missLeft:        missSize,
+		hash:            hash,
+		refreshDuration: cfg.refreshInterval,
+		batchDelay:      cfg.batchDelay,
+		life:            lifecycle{closed: make(chan struct{})},
+		clock:           cfg.clock,
+		policy:          cfg.policy,
+		keyCodec:        GobCodec[K]{},
+		valueCodec:      GobCodec[V]{},
+		listeners:       &evictListeners[K, V]{},
+		staleWindow:     cfg.staleWindow,
+		grace:           cfg.grace,
+		ahead:           cfg.ahead,
+		minRate:         cfg.minRate,
+		beta:            cfg.beta,
+		reloads:         newReloads[K](),
+		readThrough:     cfg.readThrough,
+		writeTimeout:    cfg.writeTimeout,
+	}
+
+	if cfg.writeBatch > 0 {
+		ret.behind = newWriteBehind[K, V](cfg.writeBatch, cfg.writeDelay, cfg.writeRetries)
+	}
+
+	for i := 0; i < ret.bucketSize; i++ {
+		front := make(map[K]*item[V], keys/ret.bucketSize)
+		back := make(map[K]*item[V], keys/ret.bucketSize)
+
+		ret.caches[i] = BaseCache[K, V]{
+			back:          &back,
+			keyBufferSize: keys / ret.bucketSize,
+			expired:       make(map[K]struct{}),
+			clock:         cfg.clock,
+			policy:        cfg.policy,
+			track:         cfg.policy != nil || cfg.ahead > 0,
+			batchSize:     cfg.batchSize,
+			batchDelay:    cfg.batchDelay,
+			listeners:     ret.listeners,
+			staleWindow:   cfg.staleWindow,
+		}
+		ret.caches[i].front.Store(&front)
+	}
+
+	return ret
+}
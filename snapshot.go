@@ -0,0 +1,256 @@
+package gocache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format SaveSnapshot writes.
+// LoadSnapshot also reads version 1, which has no costs.
+const SnapshotVersion = 2
+
+const (
+	snapshotMagic     = "GOCACHE\x00"
+	maxSnapshotLength = 1 << 30 // of a single key or value, guards allocations against corrupt lengths
+)
+
+var (
+	// ErrSnapshotCorrupt is wrapped by LoadSnapshot errors of malformed snapshots or checksum mismatches.
+	ErrSnapshotCorrupt = errors.New("gocache: corrupt snapshot")
+	// ErrSnapshotVersion is returned by LoadSnapshot for snapshots of an unknown version.
+	ErrSnapshotVersion = errors.New("gocache: unsupported snapshot version")
+)
+
+// Snapshot format, integers are big endian or varints:
+//
+//	magic "GOCACHE\x00" | version uint16 | saved at, unix nano int64
+//	entries: 1 | key length uvarint | key | remaining TTL varint | cost varint | value length uvarint | value
+//	0 | crc32 (IEEE) of everything before it, uint32
+
+// SaveSnapshot writes the live items with their remaining TTLs to w, encoded by the codecs
+// of WithKeyCodec and WithValueCodec. Stale items are written too, LoadSnapshot skips them.
+// Misses cached by StoreMiss are not written.
+func (r *Cache[K, V]) SaveSnapshot(w io.Writer) error {
+	now := r.clock.Now()
+
+	sum := crc32.NewIEEE()
+	out := bufio.NewWriter(io.MultiWriter(w, sum))
+
+	var header [len(snapshotMagic) + 2 + 8]byte
+	copy(header[:], snapshotMagic)
+	binary.BigEndian.PutUint16(header[len(snapshotMagic):], SnapshotVersion)
+	binary.BigEndian.PutUint64(header[len(snapshotMagic)+2:], uint64(now.UnixNano()))
+	out.Write(header[:])
+
+	var scratch [binary.MaxVarintLen64]byte
+	for i := 0; i < r.bucketSize; i++ {
+		for _, e := range r.caches[i].live(now) {
+			key, err := r.keyCodec.Encode(e.key)
+			if err != nil {
+				return fmt.Errorf("gocache: encode key: %w", err)
+			}
+
+			value, err := r.valueCodec.Encode(e.item.v)
+			if err != nil {
+				return fmt.Errorf("gocache: encode value: %w", err)
+			}
+
+			out.WriteByte(1)
+			out.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(key)))])
+			out.Write(key)
+			out.Write(scratch[:binary.PutVarint(scratch[:], int64(e.item.stale.Sub(now)))])
+			out.Write(scratch[:binary.PutVarint(scratch[:], e.item.cost)])
+			out.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(value)))])
+			out.Write(value)
+		}
+	}
+	out.WriteByte(0)
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], sum.Sum32())
+	_, err := w.Write(checksum[:])
+
+	return err
+}
+
+// LoadSnapshot stores the items of a snapshot written by SaveSnapshot and returns how many it stored.
+// TTLs keep running from the moment the snapshot was saved, so items that expired meanwhile are skipped.
+// Items are set like SetWithCost does, so a full cache rejects or evicts like it does for any write,
+// but they are not written to the Writer of WithWriter, the store may have newer ones. Nothing is stored
+// unless the whole snapshot is valid.
+func (r *Cache[K, V]) LoadSnapshot(rd io.Reader) (int, error) {
+	in := &snapshotReader{r: bufio.NewReader(rd), sum: crc32.NewIEEE()}
+
+	var header [len(snapshotMagic) + 2 + 8]byte
+	if err := in.full(header[:]); err != nil {
+		return 0, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("%w: bad magic", ErrSnapshotCorrupt)
+	}
+	version := binary.BigEndian.Uint16(header[len(snapshotMagic):])
+	if version != 1 && version != SnapshotVersion {
+		return 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	savedAt := time.Unix(0, int64(binary.BigEndian.Uint64(header[len(snapshotMagic)+2:])))
+
+	type entry struct {
+		key   []byte
+		ttl   time.Duration
+		cost  int64
+		value []byte
+	}
+
+	var entries []entry
+	for {
+		marker, err := in.ReadByte()
+		if err != nil {
+			return 0, in.corrupt(err)
+		}
+		if marker == 0 {
+			break
+		}
+		if marker != 1 {
+			return 0, fmt.Errorf("%w: bad entry marker %d", ErrSnapshotCorrupt, marker)
+		}
+
+		var e entry
+		if e.key, err = in.bytes(); err != nil {
+			return 0, err
+		}
+		ttl, err := binary.ReadVarint(in)
+		if err != nil {
+			return 0, in.corrupt(err)
+		}
+		e.ttl = time.Duration(ttl)
+		e.cost = computedCost
+		if version > 1 {
+			if e.cost, err = binary.ReadVarint(in); err != nil {
+				return 0, in.corrupt(err)
+			}
+		}
+		if e.value, err = in.bytes(); err != nil {
+			return 0, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	expected := in.sum.Sum32()
+	var checksum [4]byte
+	if _, err := io.ReadFull(in.r, checksum[:]); err != nil {
+		return 0, in.corrupt(err)
+	}
+	if binary.BigEndian.Uint32(checksum[:]) != expected {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+
+	elapsed := r.clock.Now().Sub(savedAt)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	type decoded struct {
+		key   K
+		value V
+		ttl   time.Duration
+		cost  int64
+	}
+
+	// decode everything before storing anything, a value the codec rejects fails the whole snapshot
+	items := make([]decoded, 0, len(entries))
+	for _, e := range entries {
+		ttl := e.ttl - elapsed
+		if ttl <= 0 {
+			continue
+		}
+
+		key, err := r.keyCodec.Decode(e.key)
+		if err != nil {
+			return 0, fmt.Errorf("gocache: decode key: %w", err)
+		}
+
+		value, err := r.valueCodec.Decode(e.value)
+		if err != nil {
+			return 0, fmt.Errorf("gocache: decode value: %w", err)
+		}
+
+		items = append(items, decoded{key: key, value: value, ttl: ttl, cost: e.cost})
+	}
+
+	loaded := 0
+	for _, d := range items {
+		if r.write(d.key, d.value, d.cost, d.ttl, modeSet, false) { // not written back, the store may be newer
+			loaded++
+		}
+	}
+
+	return loaded, nil
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	sum hash.Hash32
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.sum.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) full(buf []byte) error {
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return r.corrupt(err)
+	}
+	r.sum.Write(buf)
+	return nil
+}
+
+// bytes reads a length prefixed byte string.
+func (r *snapshotReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, r.corrupt(err)
+	}
+	if n > maxSnapshotLength {
+		return nil, fmt.Errorf("%w: length %d", ErrSnapshotCorrupt, n)
+	}
+
+	ret := make([]byte, n)
+	return ret, r.full(ret)
+}
+
+func (r *snapshotReader) corrupt(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated", ErrSnapshotCorrupt)
+	}
+	return err
+}
+
+// live returns the live items of the bucket, including pending writes, but not cached misses.
+func (r *BaseCache[K, V]) live(now time.Time) []expiryEntry[K, V] {
+	r.block.RLock()
+	defer r.block.RUnlock()
+
+	ret := make([]expiryEntry[K, V], 0, len(*r.back))
+	for k, v := range *r.back {
+		if !v.expired(now) && !v.miss {
+			ret = append(ret, expiryEntry[K, V]{key: k, item: v})
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,99 @@
+package gocache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	cache, _ := NewCacheWithOptions[string, []byte](WithKeyCodec[string](StringCodec{}), WithValueCodec[[]byte](BytesCodec{}))
+	cache.Store("a", []byte("1"), time.Minute)
+	cache.Store("b", []byte("2"), time.Hour)
+	cache.Store("expired", []byte("3"), -time.Second)
+
+	var buf bytes.Buffer
+	assert.NoError(t, cache.SaveSnapshot(&buf))
+	saved := buf.Bytes()
+
+	restored, _ := NewCacheWithOptions[string, []byte](WithCapacity(1), WithKeyCodec[string](StringCodec{}), WithValueCodec[[]byte](BytesCodec{}))
+	loaded, err := restored.LoadSnapshot(bytes.NewReader(saved))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded) // the capacity holds only one of them
+	assert.Equal(t, 1, restored.Stats().Items)
+
+	restored, _ = NewCacheWithOptions[string, []byte](WithKeyCodec[string](StringCodec{}), WithValueCodec[[]byte](BytesCodec{}))
+	loaded, err = restored.LoadSnapshot(bytes.NewReader(saved))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loaded)
+	v, ok := restored.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("2"), v)
+	_, ok = restored.Get("expired")
+	assert.False(t, ok)
+
+	corrupt := bytes.Clone(saved)
+	corrupt[len(corrupt)-6] ^= 0xff
+	_, err = restored.LoadSnapshot(bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrSnapshotCorrupt)
+
+	_, err = restored.LoadSnapshot(bytes.NewReader(saved[:len(saved)-1]))
+	assert.ErrorIs(t, err, ErrSnapshotCorrupt)
+
+	future := bytes.Clone(saved)
+	future[len(snapshotMagic)+1] = SnapshotVersion + 1
+	_, err = restored.LoadSnapshot(bytes.NewReader(future))
+	assert.ErrorIs(t, err, ErrSnapshotVersion)
+}
+
+func TestCacherSnapshot(t *testing.T) {
+	cache, _ := NewWithOptions(WithKeyString(testKey), WithValueCodec[interface{}](JSONCodec[interface{}]{}))
+	cache.Store("1", "a", time.Minute)
+
+	var buf bytes.Buffer
+	assert.NoError(t, cache.SaveSnapshot(&buf))
+
+	restored, _ := NewWithOptions(WithKeyString(testKey), WithValueCodec[interface{}](JSONCodec[interface{}]{}))
+	loaded, err := restored.LoadSnapshot(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.Equal(t, "a", restored.Get("1"))
+}
+
+func TestSnapshotNotWrittenBack(t *testing.T) {
+	var changes []Change[int, string]
+	writer := WriterFunc[int, string](func(ctx context.Context, written []Change[int, string]) error {
+		changes = append(changes, written...)
+		return nil
+	})
+
+	cache, _ := NewCacheWithOptions[int, string]()
+	cache.Set(1, "a", time.Minute)
+	cache.Set(2, "b", time.Minute)
+	var buf bytes.Buffer
+	assert.NoError(t, cache.SaveSnapshot(&buf))
+
+	restored, err := NewCacheWithOptions[int, string](WithWriter[int, string](writer))
+	assert.NoError(t, err)
+	loaded, err := restored.LoadSnapshot(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loaded)
+	assert.Empty(t, changes) // the store may have newer values than the snapshot
+}
+
+func TestSnapshotAllOrNothing(t *testing.T) {
+	cache, _ := NewCacheWithOptions[int, interface{}](WithValueCodec[interface{}](JSONCodec[interface{}]{}))
+	cache.Set(1, 1, time.Minute)
+	cache.Set(2, "not a number", time.Minute)
+	var buf bytes.Buffer
+	assert.NoError(t, cache.SaveSnapshot(&buf))
+
+	restored, _ := NewCacheWithOptions[int, int](WithValueCodec[int](JSONCodec[int]{}))
+	loaded, err := restored.LoadSnapshot(&buf)
+	assert.Error(t, err)
+	assert.Equal(t, 0, loaded)
+	assert.Equal(t, 0, restored.Stats().Items) // 1 decodes, but is not stored without 2
+}
@@ -0,0 +1,178 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadBackoff is how long Get does not reload an item again after its reload failed,
+// so a backend that is down is not called back to back for every hot key.
+const reloadBackoff = time.Second
+
+// defaultLoadTime is what XFetch assumes a reload takes before any key of the cache was reloaded.
+const defaultLoadTime = 100 * time.Millisecond
+
+// reloads tracks the background reloads of stale or hot items, at most one per key.
+type reloads[K comparable] struct {
+	lock sync.Mutex
+	keys map[K]struct{}
+	wg   sync.WaitGroup
+
+	ctx    context.Context // canceled by Close
+	cancel context.CancelFunc
+}
+
+func newReloads[K comparable]() reloads[K] {
+	ctx, cancel := context.WithCancel(context.Background())
+	return reloads[K]{keys: make(map[K]struct{}), ctx: ctx, cancel: cancel}
+}
+
+// start reports whether key is not reloading yet and marks it reloading if so.
+func (r *reloads[K]) start(key K) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.keys[key]; ok {
+		return false
+	}
+	r.keys[key] = struct{}{}
+
+	return true
+}
+
+func (r *reloads[K]) finish(key K) {
+	r.lock.Lock()
+	delete(r.keys, key)
+	r.lock.Unlock()
+}
+
+// revalidate reloads stale, the item of key, in the background unless it is reloading already
+// or its last reload failed less than reloadBackoff ago.
+func (r *Cache[K, V]) revalidate(key K, stale *item[V]) {
+	if r.loader == nil || r.clock.Now().UnixNano() < atomic.LoadInt64(&stale.retry) || !r.reloads.start(key) {
+		return
+	}
+	if _, ok := r.pending(key); ok { // the store is behind the cache
+		r.reloads.finish(key)
+		return
+	}
+
+	r.life.lock.Lock()
+	select {
+	case <-r.life.closed:
+		r.life.lock.Unlock()
+		r.reloads.finish(key)
+		return
+	default:
+	}
+	r.reloads.wg.Add(1) // under life.lock, so Close either waits for it or it does not start
+	r.life.lock.Unlock()
+
+	go r.reload(key, stale)
+}
+
+// reload replaces stale by what the loader returns. If the loader fails, stale is kept for the grace
+// of WithStaleIfError after it went stale. ErrNotFound replaces it by a cached miss, or deletes it,
+// like a value the loader says not to cache does. Replace never brings back a key deleted meanwhile.
+func (r *Cache[K, V]) reload(key K, stale *item[V]) {
+	defer r.reloads.wg.Done()
+	defer r.reloads.finish(key)
+
+	b := &r.caches[r.getBucketIndex(key)]
+	b.stats.reloads.Add(1)
+
+	start := time.Now()
+	v, duration, err := r.loader.Load(r.reloads.ctx, key)
+	took := int64(time.Since(start))
+	r.lastLoad.Store(took)
+	atomic.StoreInt64(&stale.load, took) // kept by the replacement
+
+	if errors.Is(err, ErrNotFound) { // gone meanwhile, not an error to serve stale through
+		if duration > 0 {
+			var zero V
+			r.write(key, zero, 1, duration, modeReplace, true)
+		} else {
+			r.deleteMany([]K{key}) // from the cache only, the store has just said it has none
+		}
+		return
+	}
+	if err != nil {
+		atomic.StoreInt64(&stale.retry, r.clock.Now().Add(reloadBackoff).UnixNano())
+		b.stats.reloadErrors.Add(1)
+		if r.grace > 0 {
+			b.extend(key, stale, stale.stale.Add(r.grace))
+		}
+		return
+	}
+
+	if duration > 0 {
+		r.write(key, v, computedCost, duration, modeReplace, false)
+	} else {
+		r.deleteMany([]K{key}) // stale is older than what the loader would not let be cached
+	}
+}
+
+// early reports whether a read of stored at now reloads it ahead of its TTL, see WithRefreshAhead:
+// stored is in the last part of its TTL and hot. With WithXFetch, the read also has to win an XFetch draw,
+// which gets likelier the closer stored is to its TTL and the longer its reloads take. A key never reloaded
+// is assumed to take as long as the last reload of any key, or defaultLoadTime.
+func (r *Cache[K, V]) early(stored *item[V], now time.Time) bool {
+	left := stored.stale.Sub(now)
+	if float64(left) > r.ahead*float64(stored.stale.Sub(stored.updated)) {
+		return false
+	}
+
+	age := now.Sub(stored.created).Seconds()
+	if age <= 0 || float64(atomic.LoadUint32(&stored.hits))/age < r.minRate {
+		return false
+	}
+
+	if r.beta <= 0 {
+		return true
+	}
+
+	load := atomic.LoadInt64(&stored.load)
+	if load <= 0 {
+		load = r.lastLoad.Load()
+	}
+	if load <= 0 {
+		load = int64(defaultLoadTime)
+	}
+
+	// XFetch: -ln(u) is exponentially distributed, so instances reading the same key do not reload it at once
+	return float64(load)*r.beta*-math.Log(1-rand.Float64()) >= float64(left)
+}
+
+// extend keeps stale, the item of key, until then, unless it was replaced or removed meanwhile.
+func (r *BaseCache[K, V]) extend(key K, stale *item[V], until time.Time) {
+	now := r.clock.Now()
+
+	r.lockBack()
+	defer r.unlockBack()
+	defer r.commit(now)
+
+	if (*r.back)[key] != stale || !until.After(stale.time) {
+		return
+	}
+
+	stored := &item[V]{
+		accessed: atomic.LoadInt64(&stale.accessed),
+		hits:     atomic.LoadUint32(&stale.hits),
+		retry:    atomic.LoadInt64(&stale.retry),
+		load:     atomic.LoadInt64(&stale.load),
+		v:        stale.v,
+		time:     until,
+		stale:    stale.stale,
+		created:  stale.created,
+		updated:  stale.updated,
+		cost:     stale.cost,
+	}
+
+	r.set(key, stored)
+	r.pushExpiry(key, stored)
+}
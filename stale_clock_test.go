@@ -0,0 +1,139 @@
+package gocache_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/philolight/gocache"
+	"github.com/philolight/gocache/gocachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	var fail atomic.Bool
+	loads := make(chan string, 1)
+	cache, err := gocache.NewCacheWithOptions[string, string](gocache.WithClock(clock),
+		gocache.WithStaleWhileRevalidate(10*time.Second), gocache.WithStaleIfError(time.Minute),
+		gocache.WithLoader[string, string](gocache.LoaderFunc[string, string](func(ctx context.Context, key string) (string, time.Duration, error) {
+			defer func() { loads <- key }()
+			if fail.Load() {
+				return "", 0, errors.New("backend down")
+			}
+			return key + " reloaded", time.Minute, nil
+		})))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	cache.Set("a", "a", time.Minute)
+	clock.Advance(time.Minute + time.Second)
+
+	v, ok := cache.Get("a") // stale, reloaded in the background
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+	<-loads
+	assert.Eventually(t, func() bool {
+		v, _ := cache.Get("a")
+		return v == "a reloaded"
+	}, time.Second, time.Millisecond)
+
+	// a failed reload keeps the stale value for the grace after its TTL
+	fail.Store(true)
+	clock.Advance(time.Minute + time.Second)
+	v, _ = cache.Get("a")
+	assert.Equal(t, "a reloaded", v)
+	<-loads
+	assert.Eventually(t, func() bool {
+		return cache.Stats().ReloadErrors == 1
+	}, time.Second, time.Millisecond)
+	cache.Get("a") // not reloaded again right after the failure
+	assert.Equal(t, uint64(2), cache.Stats().Reloads)
+
+	clock.Advance(30 * time.Second) // past the stale window, within the grace
+	assert.Eventually(t, func() bool {
+		_, ok := cache.Get("a")
+		return ok
+	}, time.Second, time.Millisecond)
+	<-loads
+
+	clock.Advance(time.Minute)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	assert.Equal(t, uint64(3), cache.Stats().Reloads)
+	assert.Eventually(t, func() bool {
+		return cache.Stats().ReloadErrors == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestReloadNotCached(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	loads := make(chan string, 1)
+	cache, err := gocache.NewCacheWithOptions[string, string](gocache.WithClock(clock),
+		gocache.WithStaleWhileRevalidate(10*time.Second),
+		gocache.WithLoader[string, string](gocache.LoaderFunc[string, string](func(ctx context.Context, key string) (string, time.Duration, error) {
+			defer func() { loads <- key }()
+			return key + " reloaded", 0, nil // not to be cached
+		})))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	cache.Set("a", "a", time.Minute)
+	clock.Advance(time.Minute + time.Second)
+
+	v, _ := cache.Get("a")
+	assert.Equal(t, "a", v)
+	<-loads
+	assert.Eventually(t, func() bool { // not served stale for the rest of the window
+		_, ok := cache.Get("a")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestRefreshAhead(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	loads := make(chan string, 1)
+	cache, err := gocache.NewCacheWithOptions[string, string](gocache.WithClock(clock),
+		gocache.WithRefreshAhead(0.2, 1), gocache.WithXFetch(200),
+		gocache.WithLoader[string, string](gocache.LoaderFunc[string, string](func(ctx context.Context, key string) (string, time.Duration, error) {
+			defer func() { loads <- key }()
+			return key + " reloaded", time.Minute, nil
+		})))
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	cache.Set("hot", "hot", time.Minute)
+	cache.Set("cold", "cold", time.Minute)
+	for i := 0; i < 100; i++ {
+		cache.Get("hot")
+	}
+
+	clock.Advance(30 * time.Second) // too early
+	cache.Get("hot")
+	assert.Equal(t, uint64(0), cache.Stats().Reloads)
+
+	clock.Advance(20 * time.Second) // in the last 20% of the TTL
+	cache.Get("cold")
+	assert.Eventually(t, func() bool { // by a random draw, even before any reload took a measurable time
+		cache.Get("hot")
+		return cache.Stats().Reloads == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "hot", <-loads)
+	assert.Eventually(t, func() bool {
+		v, _ := cache.Get("hot")
+		return v == "hot reloaded"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(1), cache.Stats().Reloads)
+
+	clock.Advance(15 * time.Second) // only the hot key outlived its first TTL
+	_, ok := cache.Get("hot")
+	assert.True(t, ok)
+	_, ok = cache.Get("cold")
+	assert.False(t, ok)
+}
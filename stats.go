@@ -0,0 +1,226 @@
+package gocache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the counters of a bucket or of a whole cache.
+type Stats struct {
+	Items          int    // live and not yet removed expired items
+	Hits           uint64 // Get found a live item
+	StaleHits      uint64 // hits of stale items, counted in Hits too
+	Misses         uint64 // Get found nothing or an expired item
+	NegativeHits   uint64 // Get found a miss cached by StoreMiss, counted in neither Hits nor Misses
+	Stores         uint64 // Store, Set, Add or Replace wrote
+	RejectedFull   uint64 // a new key was rejected since the cache was full
+	RejectedExists uint64 // Store or Add was rejected since the key was cached already
+	RejectedAbsent uint64 // Replace was rejected since the key was not cached
+	Evictions      uint64 // items removed to make room for new keys
+	Expirations    uint64 // expired items removed
+	Deletes        uint64 // items removed by Delete or DeleteMany
+	Swaps          uint64 // front and back swaps
+	Reloads        uint64 // background reloads of stale items
+	ReloadErrors   uint64 // reloads whose loader failed
+	Writes         uint64 // changes written to the Writer, retries included
+	WriteErrors    uint64 // writes whose Writer failed
+	WritesDropped  uint64 // write-behind changes given up after their retries
+
+	SwapWait     Histogram // waits of swaps for the readers of the old front to leave
+	BackLockWait Histogram // waits of writers for the back lock
+}
+
+// HitRatio is hits over lookups, 0 before the first lookup.
+func (r Stats) HitRatio() float64 {
+	lookups := r.Hits + r.Misses
+	if lookups == 0 {
+		return 0
+	}
+
+	return float64(r.Hits) / float64(lookups)
+}
+
+func (r *Stats) add(other Stats) {
+	r.Items += other.Items
+	r.Hits += other.Hits
+	r.StaleHits += other.StaleHits
+	r.Misses += other.Misses
+	r.NegativeHits += other.NegativeHits
+	r.Stores += other.Stores
+	r.RejectedFull += other.RejectedFull
+	r.RejectedExists += other.RejectedExists
+	r.RejectedAbsent += other.RejectedAbsent
+	r.Evictions += other.Evictions
+	r.Expirations += other.Expirations
+	r.Deletes += other.Deletes
+	r.Swaps += other.Swaps
+	r.Reloads += other.Reloads
+	r.ReloadErrors += other.ReloadErrors
+	r.Writes += other.Writes
+	r.WriteErrors += other.WriteErrors
+	r.WritesDropped += other.WritesDropped
+	r.SwapWait.add(other.SwapWait)
+	r.BackLockWait.add(other.BackLockWait)
+}
+
+// LockWaitBounds are the upper bounds of the wait Histogram buckets.
+var LockWaitBounds = [...]time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Histogram counts waits by LockWaitBounds. It is not cumulative:
+// Counts[i] is the number of waits in (LockWaitBounds[i-1], LockWaitBounds[i]],
+// and the last count is the number of waits above every bound.
+type Histogram struct {
+	Counts [len(LockWaitBounds) + 1]uint64
+	Sum    time.Duration
+}
+
+// Count is the number of observed waits.
+func (r Histogram) Count() uint64 {
+	var ret uint64
+	for _, count := range r.Counts {
+		ret += count
+	}
+	return ret
+}
+
+func (r *Histogram) add(other Histogram) {
+	for i := range r.Counts {
+		r.Counts[i] += other.Counts[i]
+	}
+	r.Sum += other.Sum
+}
+
+// CacheStats is the aggregate of the bucket stats of a cache.
+type CacheStats struct {
+	Stats
+	Size            int           // limit number of cached items, or the cost budget of WithMaxCost
+	Capacity        int           // slots or cost left
+	MissSize        int           // limit number of misses cached by StoreMiss
+	MissCapacity    int           // misses left
+	Refreshes       uint64        // refreshes of all buckets by Start
+	RefreshDuration time.Duration // how long the last refresh of all buckets took
+	Buckets         []Stats       // per bucket, in bucket index order
+}
+
+type counters struct {
+	hits           atomic.Uint64
+	staleHits      atomic.Uint64
+	misses         atomic.Uint64
+	negativeHits   atomic.Uint64
+	stores         atomic.Uint64
+	rejectedFull   atomic.Uint64
+	rejectedExists atomic.Uint64
+	rejectedAbsent atomic.Uint64
+	evictions      atomic.Uint64
+	expirations    atomic.Uint64
+	deletes        atomic.Uint64
+	swaps          atomic.Uint64
+	reloads        atomic.Uint64
+	reloadErrors   atomic.Uint64
+	writes         atomic.Uint64
+	writeErrors    atomic.Uint64
+	writesDropped  atomic.Uint64
+
+	swapWait histogram
+	backWait histogram
+}
+
+type histogram struct {
+	counts [len(LockWaitBounds) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (r *histogram) observe(wait time.Duration) {
+	i := 0
+	for i < len(LockWaitBounds) && wait > LockWaitBounds[i] {
+		i++
+	}
+
+	r.counts[i].Add(1)
+	r.sum.Add(int64(wait))
+}
+
+func (r *histogram) snapshot() Histogram {
+	var ret Histogram
+	for i := range r.counts {
+		ret.Counts[i] = r.counts[i].Load()
+	}
+	ret.Sum = time.Duration(r.sum.Load())
+	return ret
+}
+
+func (r *counters) count(result writeResult) {
+	switch result {
+	case written:
+		r.stores.Add(1)
+	case rejectedExists:
+		r.rejectedExists.Add(1)
+	case rejectedAbsent:
+		r.rejectedAbsent.Add(1)
+	case rejectedFull:
+		r.rejectedFull.Add(1)
+	}
+}
+
+func (r *counters) snapshot() Stats {
+	return Stats{
+		Hits:           r.hits.Load(),
+		StaleHits:      r.staleHits.Load(),
+		Misses:         r.misses.Load(),
+		NegativeHits:   r.negativeHits.Load(),
+		Stores:         r.stores.Load(),
+		RejectedFull:   r.rejectedFull.Load(),
+		RejectedExists: r.rejectedExists.Load(),
+		RejectedAbsent: r.rejectedAbsent.Load(),
+		Evictions:      r.evictions.Load(),
+		Expirations:    r.expirations.Load(),
+		Deletes:        r.deletes.Load(),
+		Swaps:          r.swaps.Load(),
+		Reloads:        r.reloads.Load(),
+		ReloadErrors:   r.reloadErrors.Load(),
+		Writes:         r.writes.Load(),
+		WriteErrors:    r.writeErrors.Load(),
+		WritesDropped:  r.writesDropped.Load(),
+		SwapWait:       r.swapWait.snapshot(),
+		BackLockWait:   r.backWait.snapshot(),
+	}
+}
+
+// Stats returns the counters of every bucket and their aggregate.
+func (r *Cache[K, V]) Stats() CacheStats {
+	ret := CacheStats{
+		Size:            int(r.size),
+		Capacity:        int(atomic.LoadInt64(&r.capacity)),
+		MissSize:        int(r.missSize),
+		MissCapacity:    int(atomic.LoadInt64(&r.missLeft)),
+		Refreshes:       r.refreshes.Load(),
+		RefreshDuration: time.Duration(r.lastRefresh.Load()),
+		Buckets:         make([]Stats, r.bucketSize),
+	}
+
+	for i := 0; i < r.bucketSize; i++ {
+		stats := r.caches[i].stats.snapshot()
+		stats.Items = r.caches[i].len()
+
+		ret.Buckets[i] = stats
+		ret.Stats.add(stats)
+	}
+
+	return ret
+}
+
+func (r *BaseCache[K, V]) len() int {
+	v := r.version.Load() & 1
+	r.readers[v].Add(1)
+	ret := len(*r.front.Load())
+	r.readers[v].Add(-1)
+	return ret
+}
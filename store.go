@@ -0,0 +1,272 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoLoader is returned by Load of a cache without a loader, see WithLoader.
+var ErrNoLoader = errors.New("gocache: no loader")
+
+// maxWriteBackoff bounds the backoff between the retries of a failed write-behind batch.
+const maxWriteBackoff = time.Minute
+
+// writeStripes is the number of locks writes of keys to a cache with a Writer are serialized by.
+const writeStripes = 64
+
+// Change is a write to the backing store, the value of key or its deletion.
+type Change[K comparable, V any] struct {
+	Key     K
+	Value   V    // the zero value if Deleted
+	Deleted bool // the key was deleted
+}
+
+// Writer writes changes of a cache to its backing store, see WithWriter. A failed Write is retried as a whole
+// by write-behind, so it has to be idempotent.
+type Writer[K comparable, V any] interface {
+	Write(ctx context.Context, changes []Change[K, V]) error
+}
+
+// WriterFunc is a func as a Writer.
+type WriterFunc[K comparable, V any] func(ctx context.Context, changes []Change[K, V]) error
+
+func (r WriterFunc[K, V]) Write(ctx context.Context, changes []Change[K, V]) error {
+	return r(ctx, changes)
+}
+
+// Store is a backing store the cache reads through and writes to, see WithStore.
+type Store[K comparable, V any] interface {
+	Loader[K, V]
+	Writer[K, V]
+}
+
+// Load returns the live value of key, or reads it through the loader of WithLoader on a miss
+// like GetOrLoad does. It returns ErrNoLoader without a loader.
+func (r *Cache[K, V]) Load(ctx context.Context, key K) (V, error) {
+	if r.loader == nil {
+		var zero V
+		return zero, ErrNoLoader
+	}
+
+	return r.GetOrLoad(ctx, key, func(ctx context.Context) (V, time.Duration, error) {
+		return r.loader.Load(ctx, key)
+	})
+}
+
+// put writes value to the cache and then, if it was written, to the Writer of WithWriter.
+// A failed write-through removes key from the cache again, so the cache does not keep what the store does not have.
+// Writes of the same key are serialized with a Writer, so the store ends with the value the cache ends with.
+func (r *Cache[K, V]) put(key K, value V, cost int64, duration time.Duration, mode writeMode) bool {
+	if r.writer == nil {
+		return r.write(key, value, cost, duration, mode, false)
+	}
+
+	unlock := r.lockKeys([]K{key})
+	defer unlock()
+
+	if !r.write(key, value, cost, duration, mode, false) {
+		return false
+	}
+
+	if !r.writeBack([]Change[K, V]{{Key: key, Value: value}}) {
+		r.deleteMany([]K{key})
+		return false
+	}
+
+	return true
+}
+
+// lockKeys locks the write locks of keys, in order so writes of several keys do not deadlock,
+// and returns their unlock.
+func (r *Cache[K, V]) lockKeys(keys []K) func() {
+	var stripes [writeStripes]bool
+	for _, key := range keys {
+		stripes[r.hash(key)%writeStripes] = true
+	}
+
+	for i, locked := range stripes {
+		if locked {
+			r.writeLocks[i].Lock()
+		}
+	}
+
+	return func() {
+		for i, locked := range stripes {
+			if locked {
+				r.writeLocks[i].Unlock()
+			}
+		}
+	}
+}
+
+// writeBack writes changes to the Writer, or queues them for write-behind until Close, and reports whether it did.
+func (r *Cache[K, V]) writeBack(changes []Change[K, V]) bool {
+	if r.behind != nil {
+		r.life.lock.Lock()
+		queued := !r.closed() // under life.lock, so Close either writes them or they are written through
+		if queued {
+			r.behind.add(changes, r.clock.Now())
+		}
+		r.life.lock.Unlock()
+
+		if queued {
+			return true
+		}
+	}
+
+	return r.writeChanges(context.Background(), changes)
+}
+
+// writeChanges writes changes to the Writer, cancelled after the timeout of WithWriteTimeout.
+func (r *Cache[K, V]) writeChanges(ctx context.Context, changes []Change[K, V]) bool {
+	if r.writeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.writeTimeout)
+		defer cancel()
+	}
+
+	err := r.writer.Write(ctx, changes)
+	for _, change := range changes {
+		stats := &r.caches[r.getBucketIndex(change.Key)].stats
+		stats.writes.Add(1)
+		if err != nil {
+			stats.writeErrors.Add(1)
+		}
+	}
+
+	return err == nil
+}
+
+// pending returns the change of key waiting for write-behind, the store does not have it yet.
+func (r *Cache[K, V]) pending(key K) (Change[K, V], bool) {
+	if r.behind == nil {
+		return Change[K, V]{}, false
+	}
+
+	return r.behind.get(key)
+}
+
+// flushWrites writes the changes due at now in batches, or all of them once if all is set, e.g. by Close.
+// Failed batches are retried with an exponential backoff, changes are dropped after the retries of WithWriteBehind.
+func (r *Cache[K, V]) flushWrites(ctx context.Context, now time.Time, all bool) {
+	for {
+		batch := r.behind.due(now, all)
+		if len(batch) == 0 {
+			return
+		}
+
+		changes := make([]Change[K, V], len(batch))
+		for i, queued := range batch {
+			changes[i] = queued.change
+		}
+
+		ok := r.writeChanges(ctx, changes)
+		for _, dropped := range r.behind.done(batch, ok, now, all) {
+			r.caches[r.getBucketIndex(dropped.Key)].stats.writesDropped.Add(1)
+		}
+	}
+}
+
+// writeBehind queues the changes of a cache for its Writer, the last change of a key wins.
+type writeBehind[K comparable, V any] struct {
+	lock    sync.Mutex
+	pending map[K]*queuedChange[K, V]
+
+	size    int           // changes written at once
+	delay   time.Duration // between flushes, and the backoff of the first retry
+	retries int           // of a failed change before it is dropped
+}
+
+type queuedChange[K comparable, V any] struct {
+	change   Change[K, V]
+	due      time.Time
+	attempts int
+	flushing bool // being written, kept queued meanwhile so reads still see it
+}
+
+func newWriteBehind[K comparable, V any](size int, delay time.Duration, retries int) *writeBehind[K, V] {
+	return &writeBehind[K, V]{pending: make(map[K]*queuedChange[K, V]), size: size, delay: delay, retries: retries}
+}
+
+func (r *writeBehind[K, V]) add(changes []Change[K, V], now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, change := range changes {
+		r.pending[change.Key] = &queuedChange[K, V]{change: change, due: now}
+	}
+}
+
+func (r *writeBehind[K, V]) get(key K) (Change[K, V], bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	queued, ok := r.pending[key]
+	if !ok {
+		return Change[K, V]{}, false
+	}
+
+	return queued.change, true
+}
+
+// due marks up to size changes due at now flushing and returns them.
+func (r *writeBehind[K, V]) due(now time.Time, all bool) []*queuedChange[K, V] {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var ret []*queuedChange[K, V]
+	for _, queued := range r.pending {
+		if len(ret) == r.size {
+			break
+		}
+		if queued.flushing || (!all && queued.due.After(now)) {
+			continue
+		}
+
+		queued.flushing = true
+		ret = append(ret, queued)
+	}
+
+	return ret
+}
+
+// done unqueues batch if it was written, or schedules its retry. Changes replaced meanwhile are left
+// to their newer change. It returns the changes dropped, those out of retries, or all failed ones if last is set.
+func (r *writeBehind[K, V]) done(batch []*queuedChange[K, V], written bool, now time.Time, last bool) []Change[K, V] {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var dropped []Change[K, V]
+	for _, queued := range batch {
+		key := queued.change.Key
+		if r.pending[key] != queued {
+			continue
+		}
+
+		queued.attempts++
+		if written || last || queued.attempts > r.retries {
+			delete(r.pending, key)
+			if !written {
+				dropped = append(dropped, queued.change)
+			}
+			continue
+		}
+
+		queued.flushing = false
+		queued.due = now.Add(r.backoff(queued.attempts))
+	}
+
+	return dropped
+}
+
+// backoff is the wait before the retry of a change that failed attempts times: delay, doubled by every attempt.
+func (r *writeBehind[K, V]) backoff(attempts int) time.Duration {
+	ret := r.delay
+	for i := 1; i < attempts && ret < maxWriteBackoff; i++ {
+		ret *= 2
+	}
+
+	return min(ret, maxWriteBackoff)
+}
@@ -0,0 +1,144 @@
+package gocache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/philolight/gocache"
+	"github.com/philolight/gocache/gocachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore is a backing store of string values, failing the next fail writes.
+type memoryStore struct {
+	lock   sync.Mutex
+	values map[string]string
+	writes int
+	fail   int
+}
+
+func (r *memoryStore) Load(ctx context.Context, key string) (string, time.Duration, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	v, ok := r.values[key]
+	if !ok {
+		return "", time.Minute, gocache.ErrNotFound
+	}
+	return v, time.Minute, nil
+}
+
+func (r *memoryStore) Write(ctx context.Context, changes []gocache.Change[string, string]) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.writes++
+	if r.fail > 0 {
+		r.fail--
+		return errors.New("backend down")
+	}
+
+	for _, change := range changes {
+		if change.Deleted {
+			delete(r.values, change.Key)
+		} else {
+			r.values[change.Key] = change.Value
+		}
+	}
+	return nil
+}
+
+func (r *memoryStore) get(key string) (string, int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.values[key], r.writes
+}
+
+func TestWriteBehind(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+	store := &memoryStore{values: map[string]string{"b": "b"}}
+	cache, err := gocache.NewCacheWithOptions[string, string](gocache.WithClock(clock), gocache.WithRefreshInterval(time.Hour),
+		gocache.WithStore[string, string](store), gocache.WithWriteBehind(10, time.Second, 1))
+	assert.NoError(t, err)
+
+	go cache.Run(context.Background())
+	clock.BlockUntil(2)
+
+	cache.Set("a", "a1", time.Minute)
+	cache.Set("a", "a2", time.Minute)
+	cache.Delete("b")
+	_, ok := cache.Get("b") // not read through, the store is behind
+	assert.False(t, ok)
+	v, _ := store.get("b")
+	assert.Equal(t, "b", v)
+
+	clock.Advance(time.Second) // the last change of each key at once
+	assert.Eventually(t, func() bool {
+		_, writes := store.get("a")
+		return writes == 1
+	}, time.Second, time.Millisecond)
+	v, _ = store.get("a")
+	assert.Equal(t, "a2", v)
+	v, _ = store.get("b")
+	assert.Equal(t, "", v)
+
+	// retried after the backoff, then dropped
+	store.lock.Lock()
+	store.fail = 2
+	store.lock.Unlock()
+	cache.Set("c", "c", time.Minute)
+	for i := 2; i <= 3; i++ {
+		clock.Advance(time.Second)
+		assert.Eventually(t, func() bool {
+			_, writes := store.get("c")
+			return writes == i
+		}, time.Second, time.Millisecond)
+	}
+	assert.Eventually(t, func() bool {
+		return cache.Stats().WritesDropped == 1
+	}, time.Second, time.Millisecond)
+
+	cache.Set("d", "d", time.Minute)
+	cache.Close() // writes what is left
+	v, _ = store.get("d")
+	assert.Equal(t, "d", v)
+	assert.Equal(t, uint64(2), cache.Stats().WriteErrors)
+}
+
+func TestReloadNotFound(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+
+	var changes atomic.Int32
+	store := struct {
+		gocache.Loader[string, string]
+		gocache.Writer[string, string]
+	}{
+		gocache.LoaderFunc[string, string](func(ctx context.Context, key string) (string, time.Duration, error) {
+			return "", 0, gocache.ErrNotFound
+		}),
+		gocache.WriterFunc[string, string](func(ctx context.Context, written []gocache.Change[string, string]) error {
+			changes.Add(int32(len(written)))
+			return nil
+		}),
+	}
+	cache, err := gocache.NewCacheWithOptions[string, string](gocache.WithClock(clock),
+		gocache.WithLoader[string, string](store), gocache.WithWriter[string, string](store),
+		gocache.WithStaleWhileRevalidate(10*time.Second))
+	assert.NoError(t, err)
+
+	cache.Set("a", "a", time.Minute)
+	clock.Advance(time.Minute + time.Second)
+	cache.Get("a") // stale, the reload finds the key gone
+
+	assert.Eventually(t, func() bool {
+		_, presence := cache.Lookup("a")
+		return presence == gocache.Unknown
+	}, time.Second, time.Millisecond)
+	cache.Close()
+	assert.Equal(t, int32(1), changes.Load()) // the Set only, the delete stays in the cache
+}
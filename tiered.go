@@ -0,0 +1,286 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultL1TTL limits how long items are kept in the first tier of a Tiered, see WithTierTTL.
+const DefaultL1TTL = time.Minute
+
+// TierOption configures NewTiered.
+type TierOption func(r *Tiered) error
+
+// WithTierTTL limits the TTL of items in each tier, 0 for no limit of L2. L2 hits are promoted into L1
+// for what is left of their TTL in L2, but for l1 at most, so it has to be positive, DefaultL1TTL by default.
+// Keep it short: a promoted item is not invalidated by writes of other instances to a shared L2.
+func WithTierTTL(l1, l2 time.Duration) TierOption {
+	return func(r *Tiered) error {
+		if l1 <= 0 || l2 < 0 {
+			return invalid("tier TTLs need a positive L1 and a non-negative L2, got %v and %v", l1, l2)
+		}
+		r.l1TTL, r.l2TTL = l1, l2
+		return nil
+	}
+}
+
+// WithInvalidation has writes delete keys from L1 instead of writing them there too, so L1 only holds
+// what was read.
+func WithInvalidation() TierOption {
+	return func(r *Tiered) error {
+		r.invalidate = true
+		return nil
+	}
+}
+
+// TierStats counts where the reads of a Tiered were answered.
+type TierStats struct {
+	L1Hits     uint64 // found in L1, cached misses included
+	L2Hits     uint64 // found in L2, cached misses included
+	Misses     uint64 // found in neither
+	Promotions uint64 // L2 hits stored into L1
+	L1         CacheStats
+	L2         CacheStats
+}
+
+// Tiered is a Cacher of a small, hot L1 in front of a larger L2, e.g. a local cache in front of a shared one.
+// Reads try L1 first and promote L2 hits into L1. Writes go to L2 first and then to L1, or delete the key
+// from L1 with WithInvalidation; a write L2 rejects deletes the key from L1, so L1 never holds
+// a value older than what L2 has.
+type Tiered struct {
+	l1, l2     Cacher
+	l1TTL      time.Duration
+	l2TTL      time.Duration // 0 for no limit
+	invalidate bool
+
+	l1Hits       atomic.Uint64
+	l2Hits       atomic.Uint64
+	negativeHits atomic.Uint64 // of both tiers, counted in their hits too
+	misses       atomic.Uint64
+	promotions   atomic.Uint64
+}
+
+// NewTiered returns a Tiered of l1 and l2, or an error wrapping ErrInvalidOption.
+func NewTiered(l1, l2 Cacher, opts ...TierOption) (*Tiered, error) {
+	if l1 == nil || l2 == nil {
+		return nil, invalid("tiers must not be nil")
+	}
+
+	ret := &Tiered{l1: l1, l2: l2, l1TTL: DefaultL1TTL}
+	for _, opt := range opts {
+		if err := opt(ret); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+func (r *Tiered) limit(duration time.Duration, ttl time.Duration) time.Duration {
+	if ttl > 0 && duration > ttl {
+		return ttl
+	}
+	return duration
+}
+
+// Run runs both tiers at once until ctx is done or one of them returns, e.g. as it was closed,
+// and returns the error of the tier that returned first.
+func (r *Tiered) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	for _, tier := range []Cacher{r.l1, r.l2} {
+		go func(tier Cacher) {
+			errs <- tier.Run(ctx)
+		}(tier)
+	}
+
+	err := <-errs
+	cancel() // stop the other tier
+	<-errs
+
+	return err
+}
+
+// Close closes L1, then L2.
+func (r *Tiered) Close() error {
+	return errors.Join(r.l1.Close(), r.l2.Close())
+}
+
+// Start runs both tiers until Stop. Prefer Run and Close.
+func (r *Tiered) Start() {
+	r.Run(context.Background())
+}
+
+// Stop is Close.
+func (r *Tiered) Stop() {
+	r.Close()
+}
+
+func (r *Tiered) Get(key interface{}) interface{} {
+	stored, _, _ := r.LookupWithTTL(key)
+	return stored
+}
+
+func (r *Tiered) Lookup(key interface{}) (interface{}, Presence) {
+	stored, presence, _ := r.LookupWithTTL(key)
+	return stored, presence
+}
+
+// LookupWithTTL tries L1, then L2, promoting what L2 knows, a value or a cached miss, into L1
+// for what is left of its TTL, up to the L1 limit of WithTierTTL.
+func (r *Tiered) LookupWithTTL(key interface{}) (interface{}, Presence, time.Duration) {
+	if stored, presence, ttl := r.l1.LookupWithTTL(key); presence != Unknown {
+		r.l1Hits.Add(1)
+		if presence == Absent {
+			r.negativeHits.Add(1)
+		}
+		return stored, presence, ttl
+	}
+
+	stored, presence, ttl := r.l2.LookupWithTTL(key)
+	switch presence {
+	case Present:
+		r.l2Hits.Add(1)
+		r.promote(key, stored, ttl)
+	case Absent:
+		r.l2Hits.Add(1)
+		r.negativeHits.Add(1)
+		if ttl > 0 {
+			r.l1.StoreMiss(key, r.limit(ttl, r.l1TTL))
+		}
+	default:
+		r.misses.Add(1)
+	}
+
+	return stored, presence, ttl
+}
+
+// Load is Lookup reading a miss of both tiers through the loader of L2. A loaded value is promoted
+// into L1 by the next lookup, L2 does not tell how long it is left to live.
+func (r *Tiered) Load(ctx context.Context, key interface{}) (interface{}, error) {
+	switch stored, presence := r.Lookup(key); presence {
+	case Present:
+		return stored, nil
+	case Absent:
+		return stored, ErrNotFound
+	}
+
+	return r.l2.Load(ctx, key)
+}
+
+// promote stores an L2 hit with ttl left into L1 unless a write got there first. A stale hit is not promoted.
+func (r *Tiered) promote(key interface{}, value interface{}, ttl time.Duration) {
+	if ttl > 0 && r.l1.Add(key, value, r.limit(ttl, r.l1TTL)) {
+		r.promotions.Add(1)
+	}
+}
+
+// written writes what L2 took to L1 by write, or deletes key from L1.
+func (r *Tiered) written(key interface{}, ok bool, write func(duration time.Duration) bool, duration time.Duration) bool {
+	if !ok || r.invalidate || !write(r.limit(duration, r.l1TTL)) {
+		r.l1.Delete(key)
+	}
+	return ok
+}
+
+func (r *Tiered) Store(key interface{}, value interface{}, duration time.Duration) bool {
+	return r.Add(key, value, duration)
+}
+
+func (r *Tiered) StoreWithCost(key interface{}, value interface{}, cost int64, duration time.Duration) bool {
+	ok := r.l2.StoreWithCost(key, value, cost, r.limit(duration, r.l2TTL))
+	return r.written(key, ok, func(duration time.Duration) bool {
+		return r.l1.SetWithCost(key, value, cost, duration)
+	}, duration)
+}
+
+func (r *Tiered) Set(key interface{}, value interface{}, duration time.Duration) bool {
+	ok := r.l2.Set(key, value, r.limit(duration, r.l2TTL))
+	return r.written(key, ok, func(duration time.Duration) bool {
+		return r.l1.Set(key, value, duration)
+	}, duration)
+}
+
+func (r *Tiered) SetWithCost(key interface{}, value interface{}, cost int64, duration time.Duration) bool {
+	ok := r.l2.SetWithCost(key, value, cost, r.limit(duration, r.l2TTL))
+	return r.written(key, ok, func(duration time.Duration) bool {
+		return r.l1.SetWithCost(key, value, cost, duration)
+	}, duration)
+}
+
+// Add stores value only if L2 does not have key yet.
+func (r *Tiered) Add(key interface{}, value interface{}, duration time.Duration) bool {
+	ok := r.l2.Add(key, value, r.limit(duration, r.l2TTL))
+	return r.written(key, ok, func(duration time.Duration) bool {
+		return r.l1.Set(key, value, duration)
+	}, duration)
+}
+
+// Replace stores value only if L2 has key.
+func (r *Tiered) Replace(key interface{}, value interface{}, duration time.Duration) bool {
+	ok := r.l2.Replace(key, value, r.limit(duration, r.l2TTL))
+	return r.written(key, ok, func(duration time.Duration) bool {
+		return r.l1.Set(key, value, duration)
+	}, duration)
+}
+
+func (r *Tiered) StoreMiss(key interface{}, ttl time.Duration) bool {
+	ok := r.l2.StoreMiss(key, r.limit(ttl, r.l2TTL))
+	return r.written(key, ok, func(duration time.Duration) bool {
+		return r.l1.StoreMiss(key, duration)
+	}, ttl)
+}
+
+// Delete deletes key from both tiers and reports whether either had it.
+func (r *Tiered) Delete(key interface{}) bool {
+	ok := r.l2.Delete(key)
+	return r.l1.Delete(key) || ok
+}
+
+// DeleteMany deletes keys from both tiers and returns how many of them L2 had.
+func (r *Tiered) DeleteMany(keys ...interface{}) int {
+	ret := r.l2.DeleteMany(keys...)
+	r.l1.DeleteMany(keys...)
+	return ret
+}
+
+// Stats returns the stats of L2 with the lookups of both tiers, see TierStats.
+func (r *Tiered) Stats() CacheStats {
+	ret := r.l2.Stats()
+	ret.NegativeHits = r.negativeHits.Load()
+	ret.Hits = r.l1Hits.Load() + r.l2Hits.Load() - ret.NegativeHits
+	ret.Misses = r.misses.Load()
+	return ret
+}
+
+// TierStats returns the hits of each tier and the stats of both.
+func (r *Tiered) TierStats() TierStats {
+	return TierStats{
+		L1Hits:     r.l1Hits.Load(),
+		L2Hits:     r.l2Hits.Load(),
+		Misses:     r.misses.Load(),
+		Promotions: r.promotions.Load(),
+		L1:         r.l1.Stats(),
+		L2:         r.l2.Stats(),
+	}
+}
+
+// OnEvict registers fn for what leaves L2, leaving L1 only does not leave the cache.
+func (r *Tiered) OnEvict(fn func(key string, value interface{}, reason EvictReason)) {
+	r.l2.OnEvict(fn)
+}
+
+// SaveSnapshot saves L2, which has everything L1 has but what L2 evicted.
+func (r *Tiered) SaveSnapshot(w io.Writer) error {
+	return r.l2.SaveSnapshot(w)
+}
+
+// LoadSnapshot loads into L2, L1 is filled by promotion.
+func (r *Tiered) LoadSnapshot(rd io.Reader) (int, error) {
+	return r.l2.LoadSnapshot(rd)
+}
@@ -0,0 +1,81 @@
+package gocache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/philolight/gocache"
+	"github.com/philolight/gocache/gocachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTiers(t *testing.T, clock gocache.Clock) (*gocache.Tiered, gocache.Cacher) {
+	keyString := func(key interface{}) string { return key.(string) }
+	l1, err := gocache.NewWithOptions(gocache.WithClock(clock), gocache.WithKeyString(keyString), gocache.WithCapacity(10))
+	assert.NoError(t, err)
+	l2, err := gocache.NewWithOptions(gocache.WithClock(clock), gocache.WithKeyString(keyString))
+	assert.NoError(t, err)
+
+	ret, err := gocache.NewTiered(l1, l2)
+	assert.NoError(t, err)
+	return ret, l2
+}
+
+func TestTieredStart(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+	cache, _ := newTiers(t, clock)
+
+	done := make(chan struct{})
+	go func() {
+		cache.Start()
+		close(done)
+	}()
+	clock.BlockUntil(2) // both tiers run at once
+	clock.Advance(time.Second)
+
+	assert.Eventually(t, func() bool {
+		stats := cache.TierStats()
+		return stats.L1.Refreshes == 1 && stats.L2.Refreshes == 1
+	}, time.Second, time.Millisecond)
+
+	cache.Stop()
+	<-done
+}
+
+func TestTieredRunClosed(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+	cache, l2 := newTiers(t, clock)
+	defer cache.Close()
+
+	l2.Close()
+	done := make(chan error)
+	go func() {
+		done <- cache.Run(context.Background())
+	}()
+
+	select {
+	case err := <-done: // L1 is stopped with the closed L2
+		assert.ErrorIs(t, err, gocache.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestTieredPromotionTTL(t *testing.T) {
+	clock := gocachetest.NewFakeClock(time.Now())
+	cache, l2 := newTiers(t, clock)
+	defer cache.Close()
+
+	l2.Set("a", "a", 5*time.Second)
+	l2.StoreMiss("b", 5*time.Second)
+	assert.Equal(t, "a", cache.Get("a")) // promoted for the 5s left in L2, not for DefaultL1TTL
+	_, presence := cache.Lookup("b")
+	assert.Equal(t, gocache.Absent, presence)
+	assert.Equal(t, uint64(1), cache.TierStats().Promotions)
+
+	clock.Advance(6 * time.Second)
+	assert.Nil(t, cache.Get("a"))
+	_, presence = cache.Lookup("b")
+	assert.Equal(t, gocache.Unknown, presence)
+}
@@ -0,0 +1,60 @@
+package gocache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiered(t *testing.T) {
+	l1 := New(testShortKey, testKey, time.Hour, 10, 1)
+	l2 := New(testShortKey, testKey, time.Hour, 100, 1)
+	cache, err := NewTiered(l1, l2, WithTierTTL(time.Second, time.Hour))
+	assert.NoError(t, err)
+	assert.Implements(t, (*Cacher)(nil), cache)
+	defer cache.Close()
+
+	// written through both tiers, L1 for its TTL at most
+	assert.True(t, cache.Set("1", "a", 24*time.Hour))
+	assert.Equal(t, "a", l1.Get("1"))
+	assert.Equal(t, "a", l2.Get("1"))
+	assert.Equal(t, "a", cache.Get("1"))
+
+	// promoted from L2
+	l2.Set("2", "b", time.Hour)
+	assert.Equal(t, "b", cache.Get("2"))
+	assert.Equal(t, "b", l1.Get("2"))
+	assert.Equal(t, "b", cache.Get("2"))
+
+	assert.Nil(t, cache.Get("3"))
+	assert.True(t, cache.StoreMiss("4", time.Minute))
+	_, presence := cache.Lookup("4")
+	assert.Equal(t, Absent, presence)
+
+	stats := cache.TierStats()
+	assert.Equal(t, uint64(3), stats.L1Hits)
+	assert.Equal(t, uint64(1), stats.L2Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Promotions)
+	assert.Equal(t, uint64(3), cache.Stats().Hits) // the cached miss is none
+	assert.Equal(t, uint64(1), cache.Stats().NegativeHits)
+
+	// a write L2 rejects invalidates L1
+	assert.False(t, cache.Add("1", "x", time.Minute))
+	assert.Nil(t, l1.Get("1"))
+	assert.Equal(t, "a", cache.Get("1"))
+
+	assert.True(t, cache.Delete("1"))
+	assert.Nil(t, l1.Get("1"))
+	assert.Nil(t, l2.Get("1"))
+
+	invalidating, _ := NewTiered(New(testShortKey, testKey, time.Hour, 10, 1), l2, WithInvalidation())
+	invalidating.Get("2")
+	assert.True(t, invalidating.Set("2", "c", time.Minute))
+	assert.Equal(t, uint64(1), invalidating.TierStats().L1.Stores) // only the promotion
+	assert.Equal(t, "c", invalidating.Get("2"))
+
+	_, err = NewTiered(l1, l2, WithTierTTL(0, 0))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}